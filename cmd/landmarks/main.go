@@ -0,0 +1,133 @@
+/*
+Precomputes the landmark distance table used by the ALT path finder in
+search/alt.
+
+Runs a forward and a backward exhaustive breadth first search over the bolt
+index from each landmark. Unless the landmarks are given explicitly, they are
+picked one at a time, starting from the seed page, by taking the page that is
+furthest from all of the landmarks picked so far.
+
+This needs an index with backlinks, see cmd/localimport.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/kbuzsaki/wikidegree/search/alt"
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+const defaultNumLandmarks = 16
+const defaultNumLoaderThreads = 10
+
+func main() {
+	tableFilename := flag.String("out", alt.DefaultLandmarksName, "the landmark distance table to create")
+	numLandmarks := flag.Int("n", defaultNumLandmarks, "the number of landmarks to pick")
+	seed := flag.String("seed", "Philosophy", "the first landmark when picking landmarks")
+	explicit := flag.String("landmarks", "", "'|' separated landmark titles to use instead of picking them")
+	flag.Parse()
+
+	go func() {
+		log.Println(http.ListenAndServe("localhost:6060", nil))
+	}()
+
+	pageLoader, err := wiki.GetBoltPageLoader()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pageLoader.Close()
+
+	backlinkLoader, ok := pageLoader.(wiki.BacklinkLoader)
+	if !ok {
+		log.Fatal("Page loader does not support backlinks")
+	}
+
+	var landmarks []string
+	if *explicit != "" {
+		for _, title := range strings.Split(*explicit, "|") {
+			landmarks = append(landmarks, wiki.NormalizeTitle(title))
+		}
+		*numLandmarks = len(landmarks)
+	}
+
+	table, err := alt.CreateLandmarkTable(*tableFilename, *numLandmarks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer table.Close()
+
+	ctx := context.Background()
+
+	// the distance from the closest landmark to each page so far
+	closest := make(map[string]uint8)
+	next := wiki.NormalizeTitle(*seed)
+
+	for i := 0; i < *numLandmarks; i++ {
+		landmark := next
+		if landmarks != nil {
+			landmark = landmarks[i]
+		}
+		if landmark == "" {
+			log.Fatal("Ran out of pages to use as landmarks after ", i)
+		}
+
+		fmt.Println("Landmark", i, ":", landmark)
+		start := time.Now()
+
+		forward, err := alt.ForwardDistances(ctx, pageLoader, landmark, defaultNumLoaderThreads)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := table.SaveDistances(i, true, forward); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Reached", len(forward), "pages forwards (", time.Since(start), ")")
+
+		backward, err := alt.BackwardDistances(ctx, backlinkLoader, landmark, defaultNumLoaderThreads)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := table.SaveDistances(i, false, backward); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Reached", len(backward), "pages backwards (", time.Since(start), ")")
+
+		if err := table.SetLandmark(i, landmark); err != nil {
+			log.Fatal(err)
+		}
+
+		next = furthest(closest, forward)
+	}
+}
+
+// Folds the distances from the newest landmark into closest and returns the
+// page that is furthest from any landmark
+func furthest(closest map[string]uint8, distances map[string]uint8) string {
+	for title, distance := range distances {
+		if previous, ok := closest[title]; !ok || distance < previous {
+			closest[title] = distance
+		}
+	}
+
+	var furthestTitle string
+	var furthestDistance uint8
+	for title, distance := range closest {
+		if distance == alt.Unreachable {
+			continue
+		}
+		if distance > furthestDistance || (distance == furthestDistance && title < furthestTitle) {
+			furthestTitle = title
+			furthestDistance = distance
+		}
+	}
+
+	return furthestTitle
+}
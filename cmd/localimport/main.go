@@ -18,10 +18,13 @@ import (
 const defaultXmlDumpFilename = "xml/enwiki-20151201-pages-articles.xml"
 const printThresh = 10000
 const bufferMax = 10000
+const backlinkBufferMax = 10 * 1000 * 1000
 
 func main() {
 	xmlDumpFilename := flag.String("xml", defaultXmlDumpFilename, "the full text xml dump to import from")
 	indexFilename := flag.String("index", wiki.DefaultIndexName, "the boltdb index db")
+	onlyBacklinks := flag.Bool("onlybacklinks", false, "only build the backlinks for an existing index")
+	textFilename := flag.String("text", "", "also save the text of every page to this db for explaining paths, normally "+wiki.DefaultTextIndexName)
 	flag.Parse()
 
 	go func() {
@@ -29,18 +32,33 @@ func main() {
 	}()
 
 	fmt.Println("Starting...")
-	load(*xmlDumpFilename, *indexFilename)
+	if !*onlyBacklinks {
+		load(*xmlDumpFilename, *indexFilename, *textFilename)
+	}
+
+	fmt.Println("Building backlinks...")
+	buildBacklinks(*indexFilename)
 }
 
-func load(xmlDumpFilename, indexFilename string) {
+func load(xmlDumpFilename, indexFilename, textFilename string) {
 	xmlPages := make(chan XmlPage, 1000)
 	pages := make(chan []wiki.Page, 1000)
 
+	// the text is only kept if there's somewhere to save it
+	var texts chan map[string]string
+	if textFilename != "" {
+		texts = make(chan map[string]string, 100)
+	}
+
 	wg := &sync.WaitGroup{}
 	wg.Add(3)
 	go loadPagesFromXml(wg, xmlDumpFilename, xmlPages)
-	go aggregatePages(wg, xmlPages, pages)
+	go aggregatePages(wg, xmlPages, pages, texts)
 	go savePages(wg, indexFilename, pages)
+	if texts != nil {
+		wg.Add(1)
+		go saveTexts(wg, textFilename, texts)
+	}
 	wg.Wait()
 }
 
@@ -84,23 +102,34 @@ func loadPagesFromXml(wg *sync.WaitGroup, filename string, xmlPages chan<- XmlPa
 	close(xmlPages)
 }
 
-func aggregatePages(wg *sync.WaitGroup, xmlPages <-chan XmlPage, pages chan<- []wiki.Page) {
+func aggregatePages(wg *sync.WaitGroup, xmlPages <-chan XmlPage, pages chan<- []wiki.Page, texts chan<- map[string]string) {
 	defer wg.Done()
 
 	var pageBuffer []wiki.Page
+	textBuffer := make(map[string]string)
 	counter := 0
 	start := time.Now()
 
 	for xmlPage := range xmlPages {
 		title := wiki.NormalizeTitle(xmlPage.Title)
 		redirect := wiki.NormalizeTitle(xmlPage.Redirect.Title)
-		links := wiki.ParseLinks(xmlPage.Text)
-		page := wiki.Page{Title: title, Redirect: redirect, Links: links}
+		links, weights, contexts := wiki.ParseLinkContexts(xmlPage.Text)
+		categories := wiki.ParseCategories(xmlPage.Text)
+		page := wiki.Page{Title: title, Redirect: redirect, Links: links, Weights: weights, Contexts: contexts, Categories: categories}
 		pageBuffer = append(pageBuffer, page)
 
+		if texts != nil && redirect == "" {
+			textBuffer[title] = xmlPage.Text
+		}
+
 		if len(pageBuffer) >= bufferMax {
 			pages <- pageBuffer
 			pageBuffer = nil
+
+			if texts != nil {
+				texts <- textBuffer
+				textBuffer = make(map[string]string)
+			}
 		}
 
 		counter++
@@ -113,6 +142,11 @@ func aggregatePages(wg *sync.WaitGroup, xmlPages <-chan XmlPage, pages chan<- []
 
 	pages <- pageBuffer
 	close(pages)
+
+	if texts != nil {
+		texts <- textBuffer
+		close(texts)
+	}
 }
 
 func savePages(wg *sync.WaitGroup, indexFilename string, pages <-chan []wiki.Page) {
@@ -131,3 +165,97 @@ func savePages(wg *sync.WaitGroup, indexFilename string, pages <-chan []wiki.Pag
 		}
 	}
 }
+
+func saveTexts(wg *sync.WaitGroup, textFilename string, texts <-chan map[string]string) {
+	defer wg.Done()
+
+	textSaver, err := wiki.GetBoltTextSaver(textFilename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer textSaver.Close()
+
+	for textBuffer := range texts {
+		err := textSaver.SaveTexts(textBuffer)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Makes a second pass over the saved index to record, for every page, the
+// pages that link to it and the redirects that point to it, and for every
+// category, the pages in it.
+// Backlinks are buffered in memory and flushed every backlinkBufferMax links.
+func buildBacklinks(indexFilename string) {
+	backlinkSaver, err := wiki.GetBoltBacklinkSaver(indexFilename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer backlinkSaver.Close()
+
+	categorySaver, ok := backlinkSaver.(wiki.CategorySaver)
+	if !ok {
+		log.Fatal("Index can't save category members")
+	}
+
+	backlinks := make(map[string][]string)
+	redirectors := make(map[string][]string)
+	members := make(map[string][]string)
+	buffered := 0
+	counter := 0
+	start := time.Now()
+
+	flush := func() error {
+		err := backlinkSaver.SaveBacklinks(backlinks, redirectors)
+		if err != nil {
+			return err
+		}
+		err = categorySaver.SaveCategoryMembers(members)
+		backlinks = make(map[string][]string)
+		redirectors = make(map[string][]string)
+		members = make(map[string][]string)
+		buffered = 0
+		return err
+	}
+
+	err = backlinkSaver.ForEachPage(func(page wiki.Page) error {
+		if page.Redirect != "" {
+			redirectors[page.Redirect] = append(redirectors[page.Redirect], page.Title)
+			buffered++
+		} else {
+			seen := make(map[string]bool)
+			for _, link := range page.Links {
+				if !seen[link] {
+					seen[link] = true
+					backlinks[link] = append(backlinks[link], page.Title)
+					buffered++
+				}
+			}
+
+			for _, category := range page.Categories {
+				members[category] = append(members[category], page.Title)
+				buffered++
+			}
+		}
+
+		counter++
+		if counter%printThresh == 0 {
+			fmt.Println(counter, "(", time.Since(start), ")")
+			start = time.Now()
+		}
+
+		if buffered >= backlinkBufferMax {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = flush()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Builds the in-memory link graph from the bolt index and saves it as a
+snapshot file that the "graph" page source can load quickly.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kbuzsaki/wikidegree/graph"
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+func main() {
+	snapshotFilename := flag.String("out", graph.DefaultSnapshotName, "the graph snapshot file to create")
+	flag.Parse()
+
+	pageLoader, err := wiki.GetBoltPageLoader()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pageLoader.Close()
+
+	pageIterator, ok := pageLoader.(wiki.PageIterator)
+	if !ok {
+		log.Fatal("Page loader can't iterate over pages")
+	}
+
+	fmt.Println("Building graph...")
+	start := time.Now()
+	g, err := graph.Build(pageIterator)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Built graph of", g.NumPages(), "pages (", time.Since(start), ")")
+
+	fmt.Println("Saving to", *snapshotFilename)
+	err = g.SaveFile(*snapshotFilename)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"net/http"
 
 	"log"
@@ -9,14 +10,24 @@ import (
 )
 
 func main() {
-	s, err := server.New()
+	source := flag.String("src", "bolt", "the source for page loading")
+	algorithm := flag.String("alg", "bfs", "the path finding algorithm")
+	flag.Parse()
+
+	s, err := server.New(*source, *algorithm)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	http.Handle("/", http.FileServer(http.Dir("./static")))
 	http.HandleFunc("/api/path", s.HandlePathLookup)
+	http.HandleFunc("/api/paths", s.HandleAllPathsLookup)
+	http.HandleFunc("/api/paths/batch", s.HandleBatchPathLookup)
 	http.HandleFunc("/api/page", s.HandlePageLookup)
+	http.HandleFunc("/api/backlinks", s.HandleBacklinksLookup)
+	http.HandleFunc("/api/histogram", s.HandleHistogramLookup)
+	http.HandleFunc("/api/distances", s.HandleDistancesLookup)
+	http.HandleFunc("/api/cache", s.HandlePageCacheLookup)
 
 	err = http.ListenAndServe(":8080", nil)
 	if err != nil {
@@ -6,22 +6,44 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/kbuzsaki/wikidegree/graph"
+	"github.com/kbuzsaki/wikidegree/search/alt"
 	"github.com/kbuzsaki/wikidegree/search/bfs"
+	"github.com/kbuzsaki/wikidegree/search/bidir"
+	"github.com/kbuzsaki/wikidegree/search/cache"
+	"github.com/kbuzsaki/wikidegree/search/dijkstra"
 	"github.com/kbuzsaki/wikidegree/search/iddfs"
+	"github.com/kbuzsaki/wikidegree/search/waypoint"
+	"github.com/kbuzsaki/wikidegree/search/yen"
 	"github.com/kbuzsaki/wikidegree/wiki"
 )
 
 type parameters struct {
-	source    string
-	algorithm string
-	start     string
-	end       string
-	verbose   bool
+	command      string
+	source       string
+	algorithm    string
+	start        string
+	end          string
+	waypoints    []string
+	starts       []string
+	ends         []string
+	category     string
+	distinct     bool
+	verbose      bool
+	explain      bool
+	cache        bool
+	all          bool
+	k            int
+	constraints  wiki.Constraints
+	memoryBudget int
+	pageCache    int
 }
 
 func main() {
@@ -40,9 +62,32 @@ func main() {
 	}
 
 	pageLoader := getPageLoader(params.source)
+	if params.pageCache != 0 {
+		pageLoader = wiki.GetCachingPageLoader(pageLoader, params.pageCache*1024*1024)
+	}
 	defer pageLoader.Close()
 
-	pathFinder := getPathFinder(params.algorithm, pageLoader)
+	if params.command == "histogram" {
+		printHistogram(pageLoader, params.start)
+		return
+	}
+
+	if params.command == "distances" {
+		printDistances(pageLoader, params)
+		return
+	}
+
+	pathFinder := getPathFinder(params.algorithm, params.memoryBudget, pageLoader)
+
+	if params.category != "" {
+		findCategoryPath(pathFinder, pageLoader, params)
+		return
+	}
+
+	if len(params.starts) > 1 || len(params.ends) > 1 {
+		findMultiPath(pathFinder, pageLoader, params)
+		return
+	}
 
 	// validate the start page
 	startPage, err := pageLoader.LoadPage(params.start)
@@ -53,8 +98,15 @@ func main() {
 		log.Fatal("Start page '" + params.start + "' has no links!")
 	}
 
+	// validate any waypoints in between
+	for _, title := range params.waypoints[1 : len(params.waypoints)-1] {
+		if _, err := pageLoader.LoadPage(title); err != nil {
+			log.Fatal("Waypoint page '" + title + "' does not exist!")
+		}
+	}
+
 	// validate the end page
-	_, err = pageLoader.LoadPage(params.end)
+	endPage, err := pageLoader.LoadPage(params.end)
 	if err != nil {
 		log.Fatal("End page '" + params.end + "' does not exist!")
 	}
@@ -64,28 +116,440 @@ func main() {
 
 	ctx := context.Background()
 
-	path, err := pathFinder.FindPath(ctx, params.start, params.end)
+	if params.verbose {
+		recorder := wiki.NewStatsRecorder()
+		ctx = wiki.WithSearchTrace(ctx, traceLevels(recorder.Trace()))
+		defer printStats(recorder)
+		if cachingPageLoader, ok := pageLoader.(wiki.CachingPageLoader); ok {
+			defer printPageCacheStats(cachingPageLoader)
+		}
+	}
+
+	if len(params.waypoints) > 2 {
+		path, err := waypoint.FindPath(ctx, pathFinder, params.waypoints, params.constraints, params.distinct)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Final path:", path)
+		if params.explain {
+			printExplanation(pageLoader, path)
+		}
+		return
+	}
+
+	if params.all {
+		allPathsFinder := getAllPathsFinder(params.algorithm, pageLoader)
+
+		dag, err := allPathsFinder.FindAllPaths(ctx, params.start, params.end)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Found", dag.Count(), "shortest paths:")
+		for _, path := range dag.Paths(0) {
+			fmt.Println(path)
+		}
+		return
+	}
+
+	if params.k > 0 {
+		kPathFinder := yen.GetYenPathFinder(pageLoader)
+
+		paths, err := kPathFinder.FindKPaths(ctx, params.start, params.end, params.k)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Found", len(paths), "paths:")
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		return
+	}
+
+	var path wiki.TitlePath
+	if params.constraints.IsEmpty() {
+		if params.cache {
+			pathCache := openPathCache(pageLoader)
+			defer pathCache.Close()
+			pathFinder = getCachedPathFinder(pathFinder, pathCache, pageLoader, params.algorithm)
+
+			// paths are cached under the titles that the server looks them up
+			// with, so that the two share them
+			path, err = pathFinder.FindPath(ctx, startPage.Title, endPage.Title)
+		} else {
+			path, err = pathFinder.FindPath(ctx, params.start, params.end)
+		}
+	} else if constrainedPathFinder, ok := pathFinder.(wiki.ConstrainedPathFinder); ok {
+		path, err = constrainedPathFinder.FindConstrainedPath(ctx, params.start, params.end, params.constraints)
+	} else {
+		log.Fatal("Constraints are not supported by algorithm: ", params.algorithm)
+	}
+	if params.constraints.MaxDepth != 0 && errors.Is(err, wiki.ErrNoPath) {
+		fmt.Println("Not reachable within", params.constraints.MaxDepth, "links")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Final path:", path)
+	if params.explain {
+		printExplanation(pageLoader, path)
+	}
+}
+
+// Prints where each link in the path is in the text of its page.
+// The web source can load the text itself, but the others need a text index.
+func printExplanation(pageLoader wiki.PageLoader, path wiki.TitlePath) {
+	textLoader, ok := pageLoader.(wiki.TextLoader)
+	if !ok {
+		var err error
+		textLoader, err = wiki.GetBoltTextLoader()
+		if err != nil {
+			log.Fatal("Paths can't be explained without a text index: ", err)
+		}
+		defer textLoader.Close()
+	}
+
+	hops, err := wiki.ExplainPath(textLoader, pageLoader, path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, hop := range hops {
+		if hop.Link == "" {
+			fmt.Printf("%s -> %s: link not found in the text\n", hop.From, hop.To)
+			continue
+		}
+		fmt.Printf("%s -> %s: \"%s\"\n", hop.From, hop.To, hop.Anchor)
+		fmt.Println("   ", hop.Snippet)
+	}
+}
+
+// Finds the shortest path from any of the start pages to any of the end pages
+func findMultiPath(pathFinder wiki.PathFinder, pageLoader wiki.PageLoader, params parameters) {
+	multiPathFinder, ok := pathFinder.(wiki.MultiPathFinder)
+	if !ok {
+		log.Fatal("Multiple start or end pages are not supported by algorithm: ", params.algorithm)
+	}
+
+	for _, title := range append(append([]string{}, params.starts...), params.ends...) {
+		if _, err := pageLoader.LoadPage(title); err != nil {
+			log.Fatal("Page '" + title + "' does not exist!")
+		}
+	}
+
+	fmt.Println("Finding shortest path from any of", params.starts, "to any of", params.ends, "using", params.algorithm)
+
+	path, err := multiPathFinder.FindMultiPath(context.Background(), params.starts, params.ends, params.constraints)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	fmt.Println("Final path:", path)
+	if params.explain {
+		printExplanation(pageLoader, path)
+	}
+}
+
+// Finds the shortest path from any of the start pages to any page in the
+// category
+func findCategoryPath(pathFinder wiki.PathFinder, pageLoader wiki.PageLoader, params parameters) {
+	multiPathFinder, ok := pathFinder.(wiki.MultiPathFinder)
+	if !ok {
+		log.Fatal("Category search is not supported by algorithm: ", params.algorithm)
+	}
+	categoryLoader, ok := pageLoader.(wiki.CategoryLoader)
+	if !ok {
+		log.Fatal("Category search is not supported by source: ", params.source)
+	}
+
+	for _, title := range params.starts {
+		if _, err := pageLoader.LoadPage(title); err != nil {
+			log.Fatal("Page '" + title + "' does not exist!")
+		}
+	}
+
+	members, err := categoryLoader.LoadCategoryMembers(params.category)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(members) == 0 {
+		log.Fatal("Category '" + params.category + "' has no pages!")
+	}
+
+	fmt.Println("Finding shortest path from any of", params.starts, "to any of the", len(members), "pages in", params.category, "using", params.algorithm)
+
+	path, err := multiPathFinder.FindMultiPath(context.Background(), params.starts, members, params.constraints)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Final path:", path)
+	if params.explain {
+		printExplanation(pageLoader, path)
+	}
+}
+
+func encodeTitles(list string) []string {
+	var titles []string
+	for _, title := range strings.Split(list, "|") {
+		if title != "" {
+			titles = append(titles, wiki.EncodeTitle(title))
+		}
+	}
+	return titles
+}
+
+// Wraps the trace so that each level is also printed as soon as it's done
+func traceLevels(trace *wiki.SearchTrace) *wiki.SearchTrace {
+	onLevel := trace.OnLevel
+	trace.OnLevel = func(level wiki.LevelStats) {
+		onLevel(level)
+		fmt.Printf("Depth %d: expanded %d pages in %v\n", level.Depth, level.Frontier, level.Elapsed)
+	}
+	return trace
+}
+
+func printStats(recorder *wiki.StatsRecorder) {
+	stats := recorder.Stats()
+	fmt.Println("Pages loaded:", stats.PagesLoaded)
+	fmt.Println("Load errors:", stats.LoadErrors)
+	fmt.Println("Depth:", stats.Depth)
+	if stats.Cached {
+		fmt.Println("Found in the path cache")
+	}
+}
+
+func printPageCacheStats(cachingPageLoader wiki.CachingPageLoader) {
+	stats := cachingPageLoader.CacheStats()
+	fmt.Println("Page cache hits:", stats.Hits)
+	fmt.Println("Page cache misses:", stats.Misses)
+	fmt.Printf("Page cache size: %d pages, %d of %d bytes\n", stats.Pages, stats.Bytes, stats.Budget)
+}
+
+func openPathCache(pageLoader wiki.PageLoader) wiki.PathCache {
+	pathCache, err := wiki.GetBoltPathCache(wiki.DefaultPathCacheName, wiki.DefaultPathCacheSize)
+	if err != nil {
+		log.Fatal("Couldn't open the path cache: ", err)
+	}
+	return pathCache
+}
+
+// Wraps the path finder so that it uses the path cache for the version of the
+// index that the page loader reads from
+func getCachedPathFinder(pathFinder wiki.PathFinder, pathCache wiki.PathCache, pageLoader wiki.PageLoader, algorithm string) wiki.PathFinder {
+	indexVersioner, ok := pageLoader.(wiki.IndexVersioner)
+	if !ok {
+		log.Fatal("Paths from this source can't be cached")
+	}
+	indexVersion, err := indexVersioner.IndexVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return cache.GetCachedPathFinder(pathFinder, pathCache, indexVersion, algorithm)
+}
+
+// Runs the histogram subcommand, printing how many pages are at each distance
+// from the source page
+func printHistogram(pageLoader wiki.PageLoader, source string) {
+	if _, err := pageLoader.LoadPage(source); err != nil {
+		log.Fatal("Source page '" + source + "' does not exist!")
+	}
+
+	fmt.Println("Finding distance histogram from", source)
+
+	histogramFinder := bfs.GetBfsHistogramFinder(pageLoader)
+	histogram, err := histogramFinder.FindHistogram(context.Background(), source)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for distance, count := range histogram.Layers {
+		fmt.Printf("%3d: %d\n", distance, count)
+	}
+	fmt.Println("Reached:", histogram.Reached)
+	if histogram.Unreachable >= 0 {
+		fmt.Println("Unreachable:", histogram.Unreachable)
+	}
+	fmt.Println("Farthest:", histogram.Farthest)
+}
+
+// Runs the distances subcommand, printing how far each of the ends is from the
+// start along with a path to it
+func printDistances(pageLoader wiki.PageLoader, params parameters) {
+	if _, err := pageLoader.LoadPage(params.start); err != nil {
+		log.Fatal("Start page '" + params.start + "' does not exist!")
+	}
+
+	// use the page titles in case there were redirects
+	targets := make([]string, len(params.ends))
+	for i, title := range params.ends {
+		page, err := pageLoader.LoadPage(title)
+		if err != nil {
+			log.Fatal("Target page '" + title + "' does not exist!")
+		}
+		targets[i] = page.Title
+	}
+
+	fmt.Println("Finding distances from", params.start, "to", len(targets), "pages")
+
+	distancesFinder := bfs.GetBfsDistancesFinder(pageLoader)
+	distances, err := distancesFinder.FindDistances(context.Background(), params.start, targets, params.constraints)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, distance := range distances {
+		if distance.Distance < 0 {
+			fmt.Printf("%s: not reachable\n", distance.Target)
+		} else {
+			fmt.Printf("%s: %d %v\n", distance.Target, distance.Distance, distance.Path)
+		}
+	}
 }
 
 func getParameters() (parameters, error) {
 	sourcePtr := flag.String("src", "bolt", "the source for page loading")
 	algorithmPtr := flag.String("alg", "bfs", "the path finding algorithm")
 	verbosePtr := flag.Bool("v", false, "enable verbose output")
+	allPtr := flag.Bool("all", false, "find every shortest path instead of just one")
+	kPtr := flag.Int("k", 0, "find the k shortest loopless paths instead of just one")
+	avoidPtr := flag.String("avoid", "", "'|' separated titles that the path may not go through")
+	skipPtr := flag.String("skip", "", "'|' separated title prefixes that the path may not go through")
+	maxDegreePtr := flag.Int("maxdegree", 0, "don't follow pages with more links than this")
+	maxDepthPtr := flag.Int("maxdepth", 0, "only look for paths with at most this many links, or 0 for no limit")
+	leadOnlyPtr := flag.Bool("leadonly", false, "only follow links in the lead section of each page")
+	maxLinksPtr := flag.Int("maxlinks", 0, "only follow the first this many links on each page, or 0 for no limit")
+	distinctPtr := flag.Bool("distinct", false, "don't revisit pages when routing through waypoints")
+	memoryBudgetPtr := flag.Int("membudget", 0, "megabytes of memory that bfs may use before spilling to disk, or 0 for no limit")
+	pageCachePtr := flag.Int("pagecache", 0, "megabytes of loaded pages to keep in memory, or 0 to not cache pages")
+	categoryPtr := flag.String("category", "", "find a path to any page in this category instead of to an end page")
+	explainPtr := flag.Bool("explain", false, "show where each link in the path is in the text of its page")
+	cachePtr := flag.Bool("cache", false, "reuse paths found before, and save new ones, in "+wiki.DefaultPathCacheName)
 	flag.Parse()
 
-	if flag.NArg() != 2 {
-		return parameters{}, fmt.Errorf("Expected exactly 2 arguments (start and end), found %d", flag.NArg())
+	if flag.Arg(0) == "histogram" {
+		if flag.NArg() != 2 {
+			return parameters{}, fmt.Errorf("Expected exactly 1 argument (source) for histogram, found %d", flag.NArg()-1)
+		}
+		source := wiki.EncodeTitle(flag.Arg(1))
+		return parameters{command: "histogram", source: *sourcePtr, start: source, verbose: *verbosePtr}, nil
+	}
+
+	// the graph is already in memory, and searches are much faster when they
+	// can see that they have one
+	if *pageCachePtr < 0 || (*pageCachePtr != 0 && *sourcePtr == "graph") {
+		return parameters{}, errors.New("-pagecache must be positive, and can't be used with the graph source")
+	}
+
+	// a negative degree would rule out every page but the start
+	if *maxDegreePtr < 0 {
+		return parameters{}, errors.New("-maxdegree can't be negative")
+	}
+
+	constraints := wiki.NewConstraints(*avoidPtr, *skipPtr, *maxDegreePtr)
+	constraints.MaxDepth = *maxDepthPtr
+	constraints.LeadOnly = *leadOnlyPtr
+	constraints.MaxLinks = *maxLinksPtr
+
+	// the targets may be separate arguments or '|' separated lists of titles
+	if flag.Arg(0) == "distances" {
+		if flag.NArg() < 3 {
+			return parameters{}, fmt.Errorf("Expected at least 2 arguments (start and targets) for distances, found %d", flag.NArg()-1)
+		}
+		var targets []string
+		for _, arg := range flag.Args()[2:] {
+			targets = append(targets, encodeTitles(arg)...)
+		}
+		return parameters{
+			command:     "distances",
+			source:      *sourcePtr,
+			start:       wiki.EncodeTitle(flag.Arg(1)),
+			ends:        targets,
+			verbose:     *verbosePtr,
+			constraints: constraints,
+			pageCache:   *pageCachePtr,
+		}, nil
+	}
+
+	// with a category, the only argument is the start, which may also be a
+	// '|' separated list of titles
+	if *categoryPtr != "" {
+		if flag.NArg() != 1 {
+			return parameters{}, fmt.Errorf("Expected exactly 1 argument (start) with -category, found %d", flag.NArg())
+		}
+		category := wiki.CategoryTitle(*categoryPtr)
+		if category == "" {
+			return parameters{}, fmt.Errorf("Invalid category: '%s'", *categoryPtr)
+		}
+		return parameters{
+			command:      "path",
+			source:       *sourcePtr,
+			algorithm:    *algorithmPtr,
+			starts:       encodeTitles(flag.Arg(0)),
+			category:     category,
+			verbose:      *verbosePtr,
+			explain:      *explainPtr,
+			constraints:  constraints,
+			memoryBudget: *memoryBudgetPtr,
+			pageCache:    *pageCachePtr,
+		}, nil
+	}
+
+	if flag.NArg() < 2 {
+		return parameters{}, fmt.Errorf("Expected at least 2 arguments (start, any waypoints, and end), found %d", flag.NArg())
+	}
+	var waypoints []string
+	for _, arg := range flag.Args() {
+		waypoints = append(waypoints, wiki.EncodeTitle(arg))
+	}
+	start := waypoints[0]
+	end := waypoints[len(waypoints)-1]
+	if len(waypoints) > 2 && constraints.MaxDepth != 0 {
+		return parameters{}, errors.New("-maxdepth can't be combined with waypoints")
+	}
+
+	// without waypoints, the start and end may each be a '|' separated list of
+	// titles, and the path may go from any of the starts to any of the ends
+	var starts, ends []string
+	if flag.NArg() == 2 {
+		starts = encodeTitles(flag.Arg(0))
+		ends = encodeTitles(flag.Arg(1))
+	}
+
+	// the k shortest paths are always found by Yen's algorithm, which doesn't
+	// support any of the other options for a path
+	if *kPtr > 0 && (*algorithmPtr != "bfs" || len(waypoints) > 2 || len(starts) > 1 || len(ends) > 1 || *allPtr || *memoryBudgetPtr != 0 || !constraints.IsEmpty()) {
+		return parameters{}, errors.New("-k can't be combined with -alg, -all, -membudget, waypoints, multiple start or end pages, or constraints")
+	}
+
+	if *cachePtr && (len(waypoints) > 2 || len(starts) > 1 || len(ends) > 1 || *allPtr || *kPtr > 0 || !constraints.IsEmpty()) {
+		return parameters{}, errors.New("-cache only applies to a single path without constraints")
 	}
-	args := flag.Args()
-	start := wiki.EncodeTitle(args[0])
-	end := wiki.EncodeTitle(args[1])
 
-	return parameters{*sourcePtr, *algorithmPtr, start, end, *verbosePtr}, nil
+	return parameters{
+		command:      "path",
+		source:       *sourcePtr,
+		algorithm:    *algorithmPtr,
+		start:        start,
+		end:          end,
+		waypoints:    waypoints,
+		starts:       starts,
+		ends:         ends,
+		distinct:     *distinctPtr,
+		verbose:      *verbosePtr,
+		explain:      *explainPtr,
+		cache:        *cachePtr,
+		all:          *allPtr,
+		k:            *kPtr,
+		constraints:  constraints,
+		memoryBudget: *memoryBudgetPtr,
+		pageCache:    *pageCachePtr,
+	}, nil
 }
 
 func getPageLoader(source string) wiki.PageLoader {
@@ -98,20 +562,53 @@ func getPageLoader(source string) wiki.PageLoader {
 		return pageLoader
 	case "web":
 		return wiki.GetWebPageLoader()
+	case "graph":
+		g, err := graph.LoadFile(graph.DefaultSnapshotName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return g
 	default:
 		log.Fatal("Unknown source:", source)
 		return nil
 	}
 }
 
-func getPathFinder(algorithm string, pageLoader wiki.PageLoader) wiki.PathFinder {
+func getPathFinder(algorithm string, memoryBudget int, pageLoader wiki.PageLoader) wiki.PathFinder {
+	if memoryBudget != 0 && algorithm != "bfs" {
+		log.Fatal("A memory budget is not supported by algorithm: ", algorithm)
+	}
+
 	switch algorithm {
 	case "bfs":
+		if memoryBudget != 0 {
+			return bfs.GetBoundedBfsPathFinder(pageLoader, memoryBudget*1024*1024)
+		}
 		return bfs.GetBfsPathFinder(pageLoader)
 	case "iddfs":
 		return iddfs.GetIddfsPathFinder(pageLoader)
+	case "bidir":
+		return bidir.GetBidirPathFinder(pageLoader)
+	case "dijkstra":
+		return dijkstra.GetDijkstraPathFinder(pageLoader)
+	case "alt":
+		table, err := alt.OpenLandmarkTable(alt.DefaultLandmarksName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return alt.GetAltPathFinder(pageLoader, table)
 	default:
 		log.Fatal("Unknown path finding algorithm: ", algorithm)
 		return nil
 	}
 }
+
+func getAllPathsFinder(algorithm string, pageLoader wiki.PageLoader) wiki.AllPathsFinder {
+	switch algorithm {
+	case "bfs":
+		return bfs.GetBfsAllPathsFinder(pageLoader)
+	default:
+		log.Fatal("Finding all paths is not supported by algorithm: ", algorithm)
+		return nil
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Implements a compact in-memory copy of the whole wikipedia link graph.
+
+Every page gets a dense uint32 id, and the links between pages are kept in
+compressed sparse row (CSR) form: the links of page id are
+edges[offsets[id]:offsets[id+1]], and likewise for the backlinks. Redirects
+and links to redirects are resolved when the graph is built, and links to
+pages that don't exist are dropped.
+
+This takes a few gigabytes of memory for all of wikipedia, but loading a
+page is just slicing an array instead of a bolt transaction, and searches
+that work with ids directly can keep track of what they have visited with a
+bitset instead of a map of strings.
+
+Building the graph from the bolt index takes a while, so it can be saved to
+and loaded from a snapshot file, see cmd/snapshot.
+*/
+package graph
+
+import (
+	"errors"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+const DefaultSnapshotName = "db/graph.snapshot"
+
+// the most redirects to follow when resolving a title
+const maxRedirectHops = 5
+
+// Implements wiki.PageLoader, wiki.BacklinkLoader, wiki.PageCounter and wiki.IndexVersioner
+type Graph struct {
+	titles []string          // the title of each id
+	ids    map[string]uint32 // the id of each title, including redirects
+
+	offsets []uint32
+	edges   []uint32
+
+	backOffsets []uint32
+	backEdges   []uint32
+
+	// identifies the snapshot that the graph was loaded from, or "" if it was
+	// built in memory
+	version string
+}
+
+// Builds the graph from every page that the iterator knows about.
+// Makes two passes: the first to give every page an id and collect the
+// redirects, and the second to resolve the links of every page to ids.
+func Build(pageIterator wiki.PageIterator) (*Graph, error) {
+	g := &Graph{ids: make(map[string]uint32)}
+	redirects := make(map[string]string)
+
+	err := pageIterator.ForEachPage(func(page wiki.Page) error {
+		if page.Redirect != "" {
+			redirects[page.Title] = page.Redirect
+		} else {
+			g.ids[page.Title] = uint32(len(g.titles))
+			g.titles = append(g.titles, page.Title)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for title := range redirects {
+		target := title
+		for hops := 0; hops < maxRedirectHops && redirects[target] != ""; hops++ {
+			target = redirects[target]
+		}
+		if id, ok := g.ids[target]; ok {
+			g.ids[title] = id
+		}
+	}
+
+	g.offsets = make([]uint32, 0, len(g.titles)+1)
+	g.offsets = append(g.offsets, 0)
+
+	err = pageIterator.ForEachPage(func(page wiki.Page) error {
+		if page.Redirect != "" {
+			return nil
+		}
+		if len(g.offsets) > len(g.titles) || g.titles[len(g.offsets)-1] != page.Title {
+			return errors.New("pages changed while building the graph")
+		}
+
+		seen := make(map[uint32]bool)
+		for _, link := range page.Links {
+			if id, ok := g.ids[link]; ok && !seen[id] {
+				seen[id] = true
+				g.edges = append(g.edges, id)
+			}
+		}
+		g.offsets = append(g.offsets, uint32(len(g.edges)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(g.offsets) != len(g.titles)+1 {
+		return nil, errors.New("pages changed while building the graph")
+	}
+
+	g.buildBacklinks()
+	return g, nil
+}
+
+// Fills in the backlinks by reversing every link
+func (g *Graph) buildBacklinks() {
+	counts := make([]uint32, len(g.titles)+1)
+	for _, id := range g.edges {
+		counts[id+1]++
+	}
+
+	g.backOffsets = make([]uint32, len(g.titles)+1)
+	for id := 1; id <= len(g.titles); id++ {
+		g.backOffsets[id] = g.backOffsets[id-1] + counts[id]
+	}
+
+	g.backEdges = make([]uint32, len(g.edges))
+	next := append([]uint32(nil), g.backOffsets[:len(g.titles)]...)
+	for source := range g.titles {
+		for _, target := range g.Links(uint32(source)) {
+			g.backEdges[next[target]] = uint32(source)
+			next[target]++
+		}
+	}
+}
+
+// The number of pages in the graph. Ids run from 0 up to this.
+func (g *Graph) NumPages() int {
+	return len(g.titles)
+}
+
+// Looks up the id of a title, following redirects
+func (g *Graph) ID(title string) (uint32, bool) {
+	id, ok := g.ids[title]
+	return id, ok
+}
+
+func (g *Graph) Title(id uint32) string {
+	return g.titles[id]
+}
+
+// The ids of the pages that the page links to.
+// The returned slice is shared and must not be modified.
+func (g *Graph) Links(id uint32) []uint32 {
+	return g.edges[g.offsets[id]:g.offsets[id+1]]
+}
+
+// The ids of the pages that link to the page.
+// The returned slice is shared and must not be modified.
+func (g *Graph) Backlinks(id uint32) []uint32 {
+	return g.backEdges[g.backOffsets[id]:g.backOffsets[id+1]]
+}
+
+// Implements wiki.PageLoader.LoadPage()
+func (g *Graph) LoadPage(title string) (wiki.Page, error) {
+	id, ok := g.ids[title]
+	if !ok {
+		return wiki.Page{}, &wiki.PageError{Title: title, Err: wiki.ErrPageNotFound}
+	}
+
+	return wiki.Page{Redirector: title, Title: g.titles[id], Links: g.titlesOf(g.Links(id))}, nil
+}
+
+// Implements wiki.BacklinkLoader.LoadBacklinks()
+func (g *Graph) LoadBacklinks(title string) ([]string, error) {
+	id, ok := g.ids[title]
+	if !ok {
+		return nil, &wiki.PageError{Title: title, Err: wiki.ErrPageNotFound}
+	}
+
+	return g.titlesOf(g.Backlinks(id)), nil
+}
+
+// Implements wiki.PageCounter.CountPages()
+func (g *Graph) CountPages() (int, error) {
+	return len(g.titles), nil
+}
+
+// Implements wiki.IndexVersioner.IndexVersion()
+// Only graphs loaded from a snapshot have a version.
+func (g *Graph) IndexVersion() (string, error) {
+	if g.version == "" {
+		return "", errors.New("graph wasn't loaded from a snapshot")
+	}
+	return g.version, nil
+}
+
+// The graph has nothing to release, it's just memory
+func (g *Graph) Close() error {
+	return nil
+}
+
+func (g *Graph) titlesOf(ids []uint32) []string {
+	titles := make([]string, len(ids))
+	for i, id := range ids {
+		titles[i] = g.titles[id]
+	}
+	return titles
+}
+
+// A set of page ids, one bit per page
+type Bitset []uint64
+
+func NewBitset(size int) Bitset {
+	return make(Bitset, (size+63)/64)
+}
+
+func (b Bitset) Set(id uint32) {
+	b[id/64] |= 1 << (id % 64)
+}
+
+func (b Bitset) Has(id uint32) bool {
+	return b[id/64]&(1<<(id%64)) != 0
+}
@@ -0,0 +1,180 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// identifies snapshot files, and their version
+var snapshotMagic = []byte("WDGRAPH1")
+
+// Saves the graph to a snapshot file that LoadFile can read back.
+//
+// The snapshot holds the titles, the redirects, and the forward links. The
+// backlinks are rebuilt from the links when the snapshot is loaded.
+func (g *Graph) SaveFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := g.save(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func (g *Graph) save(writer *bufio.Writer) error {
+	writer.Write(snapshotMagic)
+
+	writeUvarint(writer, uint64(len(g.titles)))
+	for _, title := range g.titles {
+		writeString(writer, title)
+	}
+
+	writeUvarint(writer, uint64(len(g.ids)-len(g.titles)))
+	for title, id := range g.ids {
+		if g.titles[id] != title {
+			writeString(writer, title)
+			writeUvarint(writer, uint64(id))
+		}
+	}
+
+	writeUvarint(writer, uint64(len(g.edges)))
+	if err := binary.Write(writer, binary.LittleEndian, g.offsets); err != nil {
+		return err
+	}
+	return binary.Write(writer, binary.LittleEndian, g.edges)
+}
+
+// Loads a graph from a snapshot file written by SaveFile
+func LoadFile(filename string) (*Graph, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := load(bufio.NewReaderSize(file, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	// a snapshot is only ever written all at once, so its size and when it
+	// was written are enough to tell it apart
+	g.version = fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	return g, nil
+}
+
+func load(reader *bufio.Reader) (*Graph, error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != string(snapshotMagic) {
+		return nil, errors.New("Not a graph snapshot")
+	}
+
+	numTitles, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Graph{titles: make([]string, numTitles), ids: make(map[string]uint32, numTitles)}
+	for id := range g.titles {
+		title, err := readString(reader)
+		if err != nil {
+			return nil, err
+		}
+		g.titles[id] = title
+		g.ids[title] = uint32(id)
+	}
+
+	numRedirects, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numRedirects; i++ {
+		title, err := readString(reader)
+		if err != nil {
+			return nil, err
+		}
+		id, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		if id >= numTitles {
+			return nil, errors.New("Corrupt graph snapshot")
+		}
+		g.ids[title] = uint32(id)
+	}
+
+	numEdges, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	g.offsets = make([]uint32, numTitles+1)
+	if err := binary.Read(reader, binary.LittleEndian, g.offsets); err != nil {
+		return nil, err
+	}
+	g.edges = make([]uint32, numEdges)
+	if err := binary.Read(reader, binary.LittleEndian, g.edges); err != nil {
+		return nil, err
+	}
+
+	if g.offsets[numTitles] != uint32(numEdges) {
+		return nil, errors.New("Corrupt graph snapshot")
+	}
+	for id := uint64(0); id < numTitles; id++ {
+		if g.offsets[id] > g.offsets[id+1] {
+			return nil, errors.New("Corrupt graph snapshot")
+		}
+	}
+	for _, id := range g.edges {
+		if uint64(id) >= numTitles {
+			return nil, errors.New("Corrupt graph snapshot")
+		}
+	}
+
+	g.buildBacklinks()
+	return g, nil
+}
+
+func writeUvarint(writer *bufio.Writer, value uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	writer.Write(buf[:binary.PutUvarint(buf, value)])
+}
+
+func writeString(writer *bufio.Writer, value string) {
+	writeUvarint(writer, uint64(len(value)))
+	writer.WriteString(value)
+}
+
+func readString(reader *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,204 @@
+/*
+Implements a "Six degrees of separation" style search for the shortest "path"
+between two wikipedia pages using A* search with landmarks and the triangle
+inequality (ALT).
+
+Ahead of time, cmd/landmarks picks a handful of landmark pages and records how
+far every page is from and to each of them. For any landmark L, a page v and
+the end page t, the triangle inequality gives two lower bounds on the distance
+from v to t:
+
+	d(L, t) - d(L, v)
+	d(v, L) - d(t, L)
+
+The largest of these bounds over all of the landmarks is used as the A*
+heuristic. Since it never overestimates, the search still finds a shortest
+path, but it heads almost straight for the end page instead of exploring
+everything within the same distance of the start like a breadth first search.
+
+Distances that the table doesn't know about just don't contribute a bound.
+*/
+package alt
+
+import (
+	"container/heap"
+	"context"
+	"log"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+func GetAltPathFinder(pageLoader wiki.PageLoader, table *LandmarkTable) wiki.PathFinder {
+	pathFinder := altPathFinder{pageLoader, table}
+	return &pathFinder
+}
+
+// Implements wiki.PathFinder
+type altPathFinder struct {
+	pageLoader wiki.PageLoader
+	table      *LandmarkTable
+}
+
+// Implements wiki.PathFinder.SetPageLoader()
+func (apf *altPathFinder) SetPageLoader(pageLoader wiki.PageLoader) {
+	apf.pageLoader = pageLoader
+}
+
+// Implements wiki.PathFinder.FindPath()
+func (apf *altPathFinder) FindPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
+	path, err := apf.findPath(ctx, start, end)
+	wiki.ContextSearchTrace(ctx).Done(len(path) - 1)
+	return path, err
+}
+
+// A* doesn't work in depth layers, so only page loads are reported to the
+// search trace and not levels.
+func (apf *altPathFinder) findPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
+	trace := wiki.ContextSearchTrace(ctx)
+	endDistances, _ := apf.table.Distances(end)
+	bounds := map[string]int{end: 0}
+	heuristic := func(title string) int {
+		bound, ok := bounds[title]
+		if !ok {
+			if distances, ok := apf.table.Distances(title); ok {
+				bound = lowerBound(distances, endDistances)
+			}
+			bounds[title] = bound
+		}
+		return bound
+	}
+
+	// the shortest known distance to each title and the title it was reached from
+	costs := map[string]int{start: 0}
+	parents := map[string]string{start: ""}
+
+	frontier := &nodeQueue{}
+	heap.Push(frontier, node{start, 0, heuristic(start)})
+
+	for frontier.Len() > 0 {
+		if ctx.Err() != nil {
+			return nil, wiki.CancelledError(ctx)
+		}
+
+		current := heap.Pop(frontier).(node)
+		if current.cost > costs[current.title] {
+			// a shorter path to this title has already been expanded
+			continue
+		}
+		if current.title == end {
+			return pathFromParents(parents, end), nil
+		}
+
+		page, err := apf.pageLoader.LoadPage(current.title)
+		trace.PageLoad(current.title, err)
+		if err != nil {
+			log.Println("Error loading page:", current.title, "error:", err)
+			continue
+		}
+
+		// the resolved title of a redirect takes the place of the redirect itself
+		if page.Title != current.title {
+			if previous, ok := costs[page.Title]; ok && previous <= current.cost {
+				continue
+			}
+			costs[page.Title] = current.cost
+			parents[page.Title] = parents[current.title]
+
+			if page.Title == end {
+				return pathFromParents(parents, end), nil
+			}
+		}
+
+		cost := current.cost + 1
+		for _, link := range page.Links {
+			if previous, ok := costs[link]; ok && previous <= cost {
+				continue
+			}
+
+			costs[link] = cost
+			parents[link] = page.Title
+			heap.Push(frontier, node{link, cost, cost + heuristic(link)})
+		}
+	}
+
+	return nil, wiki.ErrNoPath
+}
+
+// Returns the largest lower bound on the distance from a page to the end page
+// that the landmarks give
+func lowerBound(distances, endDistances Distances) int {
+	bound := 0
+
+	for i := range distances.From {
+		if endDistances.From == nil {
+			break
+		}
+
+		// d(L, t) <= d(L, v) + d(v, t)
+		if from, endFrom := distances.From[i], endDistances.From[i]; from != Unreachable && endFrom != Unreachable {
+			if b := int(endFrom) - int(from); b > bound {
+				bound = b
+			}
+		}
+
+		// d(v, L) <= d(v, t) + d(t, L)
+		if to, endTo := distances.To[i], endDistances.To[i]; to != Unreachable && endTo != Unreachable {
+			if b := int(to) - int(endTo); b > bound {
+				bound = b
+			}
+		}
+	}
+
+	return bound
+}
+
+func pathFromParents(parents map[string]string, end string) wiki.TitlePath {
+	var path wiki.TitlePath
+	for title := end; title != ""; title = parents[title] {
+		path = append(path, title)
+	}
+
+	path.Reverse()
+
+	return path
+}
+
+// A title waiting to be expanded by the search
+type node struct {
+	title    string
+	cost     int // the number of links from the start to the title
+	estimate int // the cost plus the lower bound on the links left to the end
+}
+
+// Implements heap.Interface, ordering nodes by estimate and then preferring
+// the ones furthest along since they are more likely to be close to the end
+type nodeQueue []node
+
+func (nq nodeQueue) Len() int {
+	return len(nq)
+}
+
+func (nq nodeQueue) Less(i, j int) bool {
+	if nq[i].estimate != nq[j].estimate {
+		return nq[i].estimate < nq[j].estimate
+	}
+	if nq[i].cost != nq[j].cost {
+		return nq[i].cost > nq[j].cost
+	}
+	return nq[i].title < nq[j].title
+}
+
+func (nq nodeQueue) Swap(i, j int) {
+	nq[i], nq[j] = nq[j], nq[i]
+}
+
+func (nq *nodeQueue) Push(item interface{}) {
+	*nq = append(*nq, item.(node))
+}
+
+func (nq *nodeQueue) Pop() interface{} {
+	length := len(*nq)
+	item := (*nq)[length-1]
+	*nq = (*nq)[:length-1]
+	return item
+}
@@ -0,0 +1,181 @@
+package alt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+const DefaultLandmarksName = "db/landmarks.db"
+
+// distance used for pages that a landmark can't reach, or can't be reached from
+const Unreachable = 255
+
+var landmarksBucket = []byte("landmarks")
+var distancesBucket = []byte("distances")
+var titlesKey = []byte("titles")
+
+// the number of distances to write per transaction
+const distanceBatchSize = 10000
+
+// A table of the distances between every page and a set of landmark pages.
+// Each page's distances are stored together as one vector: first the
+// distances from each landmark to the page, then the distances from the page
+// to each landmark.
+type LandmarkTable struct {
+	index     *bolt.DB
+	landmarks []string
+}
+
+// Distances between one page and every landmark
+type Distances struct {
+	From []uint8 // the distance from each landmark to the page
+	To   []uint8 // the distance from the page to each landmark
+}
+
+func OpenLandmarkTable(filename string) (*LandmarkTable, error) {
+	index, err := bolt.Open(filename, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	table := &LandmarkTable{index: index}
+	err = index.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(landmarksBucket)
+		if bucket == nil {
+			return errors.New("No landmarks in '" + filename + "'")
+		}
+
+		table.landmarks = strings.Split(string(bucket.Get(titlesKey)), "\n")
+		return nil
+	})
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Creates a new table with room for numLandmarks landmarks, replacing any
+// existing one. The landmarks themselves are filled in with SetLandmark.
+func CreateLandmarkTable(filename string, numLandmarks int) (*LandmarkTable, error) {
+	landmarks := make([]string, numLandmarks)
+
+	index, err := bolt.Open(filename, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = index.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{landmarksBucket, distancesBucket} {
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	return &LandmarkTable{index, landmarks}, nil
+}
+
+func (lt *LandmarkTable) Landmarks() []string {
+	return lt.landmarks
+}
+
+// Records the title of the landmark at index
+func (lt *LandmarkTable) SetLandmark(index int, title string) error {
+	lt.landmarks[index] = title
+
+	return lt.index.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(landmarksBucket).Put(titlesKey, []byte(strings.Join(lt.landmarks, "\n")))
+	})
+}
+
+// Looks up the distances between the title and every landmark.
+// Returns false if no landmark has any distance recorded for the title.
+func (lt *LandmarkTable) Distances(title string) (Distances, bool) {
+	var vector []byte
+
+	lt.index.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(distancesBucket).Get([]byte(title))
+		if len(value) == 2*len(lt.landmarks) {
+			vector = make([]byte, len(value))
+			copy(vector, value)
+		}
+		return nil
+	})
+
+	if vector == nil {
+		return Distances{}, false
+	}
+	return Distances{vector[:len(lt.landmarks)], vector[len(lt.landmarks):]}, true
+}
+
+// Records the distances between the landmark at index and every title.
+// forward means the distances are from the landmark to each title,
+// otherwise they are from each title to the landmark.
+func (lt *LandmarkTable) SaveDistances(index int, forward bool, distances map[string]uint8) error {
+	slot := index
+	if !forward {
+		slot += len(lt.landmarks)
+	}
+
+	titles := make([]string, 0, len(distances))
+	for title := range distances {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	for len(titles) > 0 {
+		batch := titles
+		if len(batch) > distanceBatchSize {
+			batch = batch[:distanceBatchSize]
+		}
+		titles = titles[len(batch):]
+
+		err := lt.index.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(distancesBucket)
+
+			for _, title := range batch {
+				vector := make([]byte, 2*len(lt.landmarks))
+				if existing := bucket.Get([]byte(title)); len(existing) == len(vector) {
+					copy(vector, existing)
+				} else {
+					for i := range vector {
+						vector[i] = Unreachable
+					}
+				}
+
+				vector[slot] = distances[title]
+				if err := bucket.Put([]byte(title), vector); err != nil {
+					return fmt.Errorf("error while saving distances for title '%s': '%v'", title, err)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (lt *LandmarkTable) Close() error {
+	return lt.index.Close()
+}
@@ -0,0 +1,79 @@
+package alt
+
+import (
+	"context"
+	"log"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// Runs an exhaustive breadth first search along the links from start and
+// returns the distance to every page it reaches. Pages that are too far away
+// to record are given a distance of Unreachable.
+func ForwardDistances(ctx context.Context, pageLoader wiki.PageLoader, start string, numLoaderThreads int) (map[string]uint8, error) {
+	loadLinks := func(title string) ([]string, error) {
+		page, err := pageLoader.LoadPage(title)
+		return page.Links, err
+	}
+
+	return distancesFrom(ctx, loadLinks, start, numLoaderThreads)
+}
+
+// Runs an exhaustive breadth first search along the backlinks to start and
+// returns the distance from every page it reaches.
+func BackwardDistances(ctx context.Context, backlinkLoader wiki.BacklinkLoader, start string, numLoaderThreads int) (map[string]uint8, error) {
+	return distancesFrom(ctx, backlinkLoader.LoadBacklinks, start, numLoaderThreads)
+}
+
+func distancesFrom(ctx context.Context, loadNeighbors func(string) ([]string, error), start string, numLoaderThreads int) (map[string]uint8, error) {
+	distances := map[string]uint8{start: 0}
+	layer := []string{start}
+
+	for depth := 1; len(layer) > 0; depth++ {
+		log.Println("Searching", len(layer), "pages at depth", depth-1)
+
+		neighbors := loadLayer(ctx, loadNeighbors, layer, numLoaderThreads)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		distance := uint8(Unreachable)
+		if depth < Unreachable {
+			distance = uint8(depth)
+		}
+
+		var nextLayer []string
+		for _, links := range neighbors {
+			for _, link := range links {
+				if _, ok := distances[link]; !ok {
+					distances[link] = distance
+					nextLayer = append(nextLayer, link)
+				}
+			}
+		}
+
+		layer = nextLayer
+	}
+
+	return distances, nil
+}
+
+// Loads the neighbors of every title in the layer using a pool of loader
+// goroutines. Titles that fail to load are treated as having no neighbors.
+func loadLayer(ctx context.Context, loadNeighbors func(string) ([]string, error), titles []string, numLoaderThreads int) [][]string {
+	neighbors := make([][]string, len(titles))
+
+	wiki.ForEachIndex(len(titles), numLoaderThreads, func(index int) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if links, err := loadNeighbors(titles[index]); err == nil {
+			neighbors[index] = links
+		} else {
+			log.Println("Error loading page:", titles[index], "error:", err)
+		}
+	})
+
+	return neighbors
+}
@@ -0,0 +1,155 @@
+package bfs
+
+import (
+	"context"
+	"log"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+func GetBfsAllPathsFinder(pageLoader wiki.PageLoader) wiki.AllPathsFinder {
+	pathFinder := bfsPathFinder{pageLoader, defaultNumScraperThreads, false, 0}
+	return &pathFinder
+}
+
+// Implements wiki.AllPathsFinder.FindAllPaths()
+//
+// Searches one whole layer at a time, remembering every parent that reaches
+// a title at its shortest depth instead of just the first one, and stops at
+// the end of the first layer that reaches the end page.
+// Links that turn out to be redirects are replaced by the titles that they
+// redirect to once they're loaded, so paths only ever hold resolved titles.
+// Links to the end's redirects count as reaching the end if the page loader
+// knows them. Otherwise they're only found when their layer is loaded, which
+// misses them if the end is linked to directly at the same depth.
+func (bpf *bfsPathFinder) FindAllPaths(ctx context.Context, start, end string) (wiki.PathDAG, error) {
+	if start == end {
+		return wiki.PathDAG{Start: start, End: end}, nil
+	}
+
+	endRedirects := bpf.loadRedirects(end)
+	parents := make(map[string][]string)
+	depths := map[string]int{start: 0}
+	layer := []string{start}
+
+	for depth := 1; len(layer) > 0; depth++ {
+		pages := wiki.LoadPages(ctx, bpf.pageLoader, layer, bpf.numScraperThreads)
+		if ctx.Err() != nil {
+			return wiki.PathDAG{}, wiki.CancelledError(ctx)
+		}
+
+		// the title that a link redirects to takes its place, unless the
+		// title was already reached by a shorter path
+		layerDepth := depth - 1
+		for i, page := range pages {
+			title := layer[i]
+			if page.Redirector == "" || page.Title == title {
+				continue
+			}
+
+			if resolvedDepth, ok := depths[page.Title]; !ok {
+				depths[page.Title] = layerDepth
+			} else if resolvedDepth != layerDepth {
+				delete(parents, title)
+				continue
+			}
+			parents[page.Title] = mergeParents(parents[page.Title], parents[title])
+			delete(parents, title)
+		}
+
+		if _, found := depths[end]; found {
+			return wiki.PathDAG{Start: start, End: end, Parents: pruneParents(parents, end)}, nil
+		}
+
+		expanded := make(map[string]bool)
+		var nextLayer []string
+		for _, page := range pages {
+			// skip pages that failed to load, that were already expanded under
+			// another of their titles, or that were reached by a shorter path
+			if page.Redirector == "" || expanded[page.Title] || depths[page.Title] != layerDepth {
+				continue
+			}
+			expanded[page.Title] = true
+			seen := make(map[string]bool)
+
+			for _, link := range page.Links {
+				if endRedirects[link] {
+					link = end
+				}
+
+				linkDepth, visited := depths[link]
+				if !visited {
+					depths[link] = depth
+					nextLayer = append(nextLayer, link)
+				} else if linkDepth != depth || seen[link] {
+					continue
+				}
+				seen[link] = true
+				parents[link] = append(parents[link], page.Title)
+			}
+		}
+
+		if _, found := depths[end]; found {
+			return wiki.PathDAG{Start: start, End: end, Parents: pruneParents(parents, end)}, nil
+		}
+
+		layer = nextLayer
+	}
+
+	return wiki.PathDAG{}, wiki.ErrNoPath
+}
+
+// Returns the titles that redirect to the title, if the page loader knows them
+func (bpf *bfsPathFinder) loadRedirects(title string) map[string]bool {
+	redirects := make(map[string]bool)
+
+	if redirectLoader, ok := bpf.pageLoader.(wiki.RedirectLoader); ok {
+		titles, err := redirectLoader.LoadRedirects(title)
+		if err != nil {
+			log.Println("Error loading redirects to:", title, "error:", err)
+		}
+		for _, redirect := range titles {
+			redirects[redirect] = true
+		}
+	}
+
+	return redirects
+}
+
+// Adds the parents that aren't already in the list
+func mergeParents(parents, added []string) []string {
+	for _, parent := range added {
+		found := false
+		for _, existing := range parents {
+			if existing == parent {
+				found = true
+				break
+			}
+		}
+		if !found {
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// Keeps only the parents of titles that are on a shortest path to end
+func pruneParents(parents map[string][]string, end string) map[string][]string {
+	pruned := make(map[string][]string)
+	queue := []string{end}
+
+	for len(queue) > 0 {
+		title := queue[0]
+		queue = queue[1:]
+
+		for _, parent := range parents[title] {
+			if _, ok := pruned[parent]; !ok && len(parents[parent]) > 0 {
+				queue = append(queue, parent)
+				pruned[parent] = nil
+			}
+		}
+		pruned[title] = parents[title]
+	}
+
+	return pruned
+}
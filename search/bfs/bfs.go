@@ -16,36 +16,43 @@ chemical_formula
 nuclear_chemistry
 vladimir_putin
 
-This algorithm is pretty friendly to parallelization, though network
-inconsistencies can make results inconsistent if multiple shortest paths
-exist.
+This algorithm is pretty friendly to parallelization. The parallel version
+loads a whole depth layer at a time so that its results stay consistent even
+when multiple shortest paths exist.
 
 Its primary weakness is that it's a big memory hog for most searches that
 require more than ~5 hops.
 Hopefully iddfs.go will help with that :)
+GetBoundedBfsPathFinder also helps by spilling the search to disk once it
+goes over a memory budget.
 */
 package bfs
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
+	"github.com/kbuzsaki/wikidegree/graph"
 	"github.com/kbuzsaki/wikidegree/wiki"
 )
 
-const defaultFrontierSize = 10 * 1000 * 1000
 const defaultNumScraperThreads = 10
 
-func GetBfsPathFinder(pageLoader wiki.PageLoader) wiki.PathFinder {
-	pathFinder := bfsPathFinder{pageLoader, defaultFrontierSize, defaultNumScraperThreads, false}
+func GetBfsPathFinder(pageLoader wiki.PageLoader) wiki.ConstrainedPathFinder {
+	pathFinder := bfsPathFinder{pageLoader, defaultNumScraperThreads, false, 0}
 	return &pathFinder
 }
 
-// Implements wiki.PathFinder
+// Implements wiki.ConstrainedPathFinder and wiki.MultiPathFinder
 type bfsPathFinder struct {
 	pageLoader        wiki.PageLoader
-	frontierSize      int
 	numScraperThreads int
 	serial            bool
+
+	// roughly how many bytes the search may keep in memory before it spills
+	// to disk, or 0 for no limit
+	memoryBudget int
 }
 
 // Implements wiki.PathFinder.SetPageLoader()
@@ -55,9 +62,49 @@ func (bpf *bfsPathFinder) SetPageLoader(pageLoader wiki.PageLoader) {
 
 // Implements wiki.PathFinder.FindPath()
 func (bpf *bfsPathFinder) FindPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
-	if bpf.serial {
-		return bpf.findNearestPathSerial(start, end)
+	return bpf.FindConstrainedPath(ctx, start, end, wiki.Constraints{})
+}
+
+// Implements wiki.ConstrainedPathFinder.FindConstrainedPath()
+func (bpf *bfsPathFinder) FindConstrainedPath(ctx context.Context, start, end string, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	return bpf.FindMultiPath(ctx, []string{start}, []string{end}, constraints)
+}
+
+// Implements wiki.MultiPathFinder.FindMultiPath()
+// Every start page begins the search at depth 0, so the path found is the
+// shortest one from any of them. Ties go to the start that comes first.
+func (bpf *bfsPathFinder) FindMultiPath(ctx context.Context, starts, ends []string, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	if len(starts) == 0 || len(ends) == 0 {
+		return nil, errors.New("At least one start and one end page are required")
+	}
+
+	endSet := make(map[string]bool)
+	for _, end := range ends {
+		endSet[end] = true
+	}
+
+	var path wiki.TitlePath
+	var err error
+
+	if g, ok := bpf.pageLoader.(*graph.Graph); ok {
+		path, err = bpf.findNearestPathGraph(ctx, g, starts, endSet, constraints)
+	} else if bpf.memoryBudget > 0 {
+		path, err = bpf.findNearestPathSpilling(ctx, starts, endSet, constraints)
+	} else if bpf.serial {
+		path, err = bpf.findNearestPathSerial(ctx, starts, endSet, constraints)
 	} else {
-		return bpf.findNearestPathParallel(ctx, start, end)
+		path, err = bpf.findNearestPathParallel(ctx, starts, endSet, constraints)
+	}
+
+	wiki.ContextSearchTrace(ctx).Done(len(path) - 1)
+	return path, err
+}
+
+// Returns the error for a search that ran out of pages without finding a path.
+// With a max depth, that means there's no path within that many links.
+func noPathError(constraints wiki.Constraints) error {
+	if constraints.MaxDepth > 0 {
+		return fmt.Errorf("%w within %d links", wiki.ErrNoPath, constraints.MaxDepth)
 	}
+	return wiki.ErrNoPath
 }
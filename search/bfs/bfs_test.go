@@ -0,0 +1,109 @@
+package bfs
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// Serves pages from memory, taking a random moment to load each one so that
+// the parallel search's loaders finish in a different order every run
+type memLoader struct {
+	links     map[string][]string
+	redirects map[string]string
+}
+
+func (ml memLoader) LoadPage(title string) (wiki.Page, error) {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+
+	resolved := title
+	if redirect, ok := ml.redirects[title]; ok {
+		resolved = redirect
+	}
+
+	links, ok := ml.links[resolved]
+	if !ok {
+		return wiki.Page{}, &wiki.PageError{Title: title, Err: wiki.ErrPageNotFound}
+	}
+	return wiki.Page{Title: resolved, Redirector: title, Links: links}, nil
+}
+
+func (ml memLoader) Close() error {
+	return nil
+}
+
+func TestParallelMatchesSerial(t *testing.T) {
+	// there are four shortest paths from Apple to Grape, and the search
+	// should always pick the one that comes first in link order
+	loader := memLoader{links: map[string][]string{
+		"Apple":  {"Banana", "Cherry", "Date"},
+		"Banana": {"Elder"},
+		"Cherry": {"Fig", "Elder"},
+		"Date":   {"Fig"},
+		"Elder":  {"Grape"},
+		"Fig":    {"Grape"},
+		"Grape":  {},
+	}}
+	want := wiki.TitlePath{"Apple", "Banana", "Elder", "Grape"}
+
+	parallel := &bfsPathFinder{loader, defaultNumScraperThreads, false, 0}
+	serial := &bfsPathFinder{loader, defaultNumScraperThreads, true, 0}
+
+	for run := 0; run < 50; run++ {
+		for name, pathFinder := range map[string]*bfsPathFinder{"parallel": parallel, "serial": serial} {
+			path, err := pathFinder.FindPath(context.Background(), "Apple", "Grape")
+			if err != nil {
+				t.Fatalf("%s run %d: unexpected error: %v", name, run, err)
+			}
+			if !reflect.DeepEqual(path, want) {
+				t.Fatalf("%s run %d: got %v, want %v", name, run, path, want)
+			}
+		}
+	}
+}
+
+func TestAllPathsThroughRedirects(t *testing.T) {
+	// Apple links straight to a redirect to Grape, and also reaches Grape the
+	// long way round
+	loader := memLoader{
+		links: map[string][]string{
+			"Apple":  {"Grapes", "Banana"},
+			"Banana": {"Cherry"},
+			"Cherry": {"Grape"},
+			"Grape":  {"Lemon"},
+			"Lemon":  {},
+		},
+		redirects: map[string]string{"Grapes": "Grape"},
+	}
+	pathFinder := &bfsPathFinder{loader, defaultNumScraperThreads, false, 0}
+
+	tests := []struct {
+		end  string
+		want []wiki.TitlePath
+	}{
+		{"Grape", []wiki.TitlePath{{"Apple", "Grape"}}},
+		{"Lemon", []wiki.TitlePath{{"Apple", "Grape", "Lemon"}}},
+	}
+
+	for _, test := range tests {
+		path, err := pathFinder.FindPath(context.Background(), "Apple", test.end)
+		if err != nil {
+			t.Fatalf("%s: unexpected error from FindPath: %v", test.end, err)
+		}
+
+		dag, err := pathFinder.FindAllPaths(context.Background(), "Apple", test.end)
+		if err != nil {
+			t.Fatalf("%s: unexpected error from FindAllPaths: %v", test.end, err)
+		}
+		if paths := dag.Paths(0); !reflect.DeepEqual(paths, test.want) || dag.Count() != len(test.want) {
+			t.Errorf("%s: got %d paths %v, want %v", test.end, dag.Count(), paths, test.want)
+		}
+		if !reflect.DeepEqual(path, test.want[0]) {
+			t.Errorf("%s: FindPath got %v, want %v", test.end, path, test.want[0])
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package bfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+func GetBfsDistancesFinder(pageLoader wiki.PageLoader) wiki.DistancesFinder {
+	pathFinder := bfsPathFinder{pageLoader, defaultNumScraperThreads, false, 0}
+	return &pathFinder
+}
+
+// Implements wiki.DistancesFinder.FindDistances()
+//
+// Runs a single search outward from the source, one layer at a time, until
+// every target has been reached or it runs out of links. Targets may be on the
+// way to other targets, so unlike FindPath the search keeps going through them.
+// The distances come back in the same order as the targets.
+// If the context is cancelled the distances found so far are returned along
+// with the error.
+func (bpf *bfsPathFinder) FindDistances(ctx context.Context, source string, targets []string, constraints wiki.Constraints) ([]wiki.TargetDistance, error) {
+	remaining := make(map[string]bool)
+	for _, target := range targets {
+		remaining[target] = true
+	}
+
+	visited := make(map[string]string)
+	visit := func(title, parent string) {
+		visited[title] = parent
+		delete(remaining, title)
+	}
+
+	visit(source, "")
+	layer := []string{source}
+	trace := wiki.ContextSearchTrace(ctx)
+
+	var err error
+	for depth := 0; len(layer) > 0 && len(remaining) > 0; depth++ {
+		levelStart := time.Now()
+
+		pages := wiki.LoadPages(ctx, bpf.pageLoader, layer, bpf.numScraperThreads)
+		if ctx.Err() != nil {
+			err = wiki.CancelledError(ctx)
+			break
+		}
+
+		// a title in the layer may have turned out to redirect to a target
+		for _, page := range pages {
+			if _, ok := visited[page.Title]; !ok && page.Redirector != "" && remaining[page.Title] {
+				visit(page.Title, visited[page.Redirector])
+			}
+		}
+
+		// the last layer is only loaded to check for redirects to the targets
+		if !constraints.AllowsLinksAt(depth) {
+			trace.Level(wiki.LevelStats{Depth: depth, Frontier: len(layer), Elapsed: time.Since(levelStart)})
+			break
+		}
+
+		var nextLayer []string
+		for _, page := range pages {
+			// skip pages that failed to load
+			if page.Redirector == "" {
+				continue
+			}
+
+			// the resolved title of a redirect takes the place of the redirect itself
+			if _, ok := visited[page.Title]; !ok {
+				visited[page.Title] = visited[page.Redirector]
+			}
+
+			// a link may have led to a redirect that the constraints rule out
+			if depth > 0 && (!constraints.Allows(page.Title) || !constraints.AllowsLinksOf(page)) {
+				continue
+			}
+
+			for i, link := range page.Links {
+				if _, ok := visited[link]; ok || !constraints.AllowsLink(page, i) {
+					continue
+				}
+
+				// targets are reached even when the constraints would rule
+				// them out, like the end of a path
+				if remaining[link] {
+					visit(link, page.Title)
+				}
+				if constraints.Allows(link) {
+					visited[link] = page.Title
+					nextLayer = append(nextLayer, link)
+				}
+			}
+		}
+
+		trace.Level(wiki.LevelStats{Depth: depth, Frontier: len(layer), Elapsed: time.Since(levelStart)})
+		layer = nextLayer
+	}
+
+	distances := make([]wiki.TargetDistance, len(targets))
+	deepest := -1
+	for i, target := range targets {
+		distances[i] = wiki.TargetDistance{Target: target, Distance: -1}
+		if _, ok := visited[target]; ok {
+			distances[i].Path = pathFromVisited(visited, target)
+			distances[i].Distance = len(distances[i].Path) - 1
+			if distances[i].Distance > deepest {
+				deepest = distances[i].Distance
+			}
+		}
+	}
+
+	trace.Done(deepest)
+	return distances, err
+}
@@ -0,0 +1,110 @@
+package bfs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kbuzsaki/wikidegree/graph"
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// how many pages to search between checks for cancellation
+const graphCancelCheckInterval = 1 << 16
+
+// bfs directly over the ids of an in-memory graph.
+// Each layer is searched in order, visited pages are kept in a bitset, and
+// each page's parent is kept in an array indexed by id, so nothing is
+// allocated per page.
+// Start pages are their own parents, which is how the path knows where to stop.
+func (bpf *bfsPathFinder) findNearestPathGraph(ctx context.Context, g *graph.Graph, starts []string, ends map[string]bool, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	if constraints.NeedsLinkContexts() {
+		return nil, errors.New("the graph doesn't know where links are on their pages")
+	}
+
+	isEnd := graph.NewBitset(g.NumPages())
+	for end := range ends {
+		endID, ok := g.ID(end)
+		if !ok {
+			return nil, &wiki.PageError{Title: end, Err: wiki.ErrPageNotFound}
+		}
+		isEnd.Set(endID)
+	}
+
+	visited := graph.NewBitset(g.NumPages())
+	parents := make([]uint32, g.NumPages())
+
+	var layer []uint32
+	for _, start := range starts {
+		startID, ok := g.ID(start)
+		if !ok {
+			return nil, &wiki.PageError{Title: start, Err: wiki.ErrPageNotFound}
+		}
+		if isEnd.Has(startID) {
+			return wiki.TitlePath{start}, nil
+		}
+		if !visited.Has(startID) {
+			visited.Set(startID)
+			parents[startID] = startID
+			layer = append(layer, startID)
+		}
+	}
+
+	searched := 0
+	trace := wiki.ContextSearchTrace(ctx)
+
+	// redirects are resolved in the graph, so unlike the other searches this
+	// one doesn't need to look at the last layer at all
+	for depth := 0; len(layer) > 0 && constraints.AllowsLinksAt(depth); depth++ {
+		var nextLayer []uint32
+		levelStart := time.Now()
+
+		for _, id := range layer {
+			if searched++; searched%graphCancelCheckInterval == 0 && ctx.Err() != nil {
+				return nil, wiki.CancelledError(ctx)
+			}
+			trace.PageLoad(g.Title(id), nil)
+
+			links := g.Links(id)
+			if depth > 0 && constraints.MaxOutDegree != 0 && len(links) > constraints.MaxOutDegree {
+				continue
+			}
+
+			for _, link := range links {
+				if visited.Has(link) {
+					continue
+				}
+				if isEnd.Has(link) {
+					parents[link] = id
+					return pathFromParents(g, parents, link), nil
+				}
+				if constraints.Allows(g.Title(link)) {
+					visited.Set(link)
+					parents[link] = id
+					nextLayer = append(nextLayer, link)
+				}
+			}
+		}
+
+		trace.Level(wiki.LevelStats{Depth: depth, Frontier: len(layer), Elapsed: time.Since(levelStart)})
+		layer = nextLayer
+	}
+
+	return nil, noPathError(constraints)
+}
+
+func pathFromParents(g *graph.Graph, parents []uint32, endID uint32) wiki.TitlePath {
+	var path wiki.TitlePath
+	id := endID
+	for ; parents[id] != id; id = parents[id] {
+		path = append(path, g.Title(id))
+	}
+	path = append(path, g.Title(id))
+
+	// reverse the path before returning
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path
+}
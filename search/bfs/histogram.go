@@ -0,0 +1,79 @@
+package bfs
+
+import (
+	"context"
+	"sort"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// the most pages to report as the farthest from the source
+const maxFarthestPages = 100
+
+func GetBfsHistogramFinder(pageLoader wiki.PageLoader) wiki.HistogramFinder {
+	pathFinder := bfsPathFinder{pageLoader, defaultNumScraperThreads, false, 0}
+	return &pathFinder
+}
+
+// Implements wiki.HistogramFinder.FindHistogram()
+//
+// Runs the search one layer at a time until it runs out of links. Pages are
+// counted by their actual title, so a page that is reached through several
+// redirects is only counted once.
+// If the context is cancelled the histogram so far is returned, marked as
+// incomplete.
+func (bpf *bfsPathFinder) FindHistogram(ctx context.Context, source string) (wiki.DistanceHistogram, error) {
+	histogram := wiki.DistanceHistogram{Source: source, Unreachable: -1}
+
+	visited := map[string]bool{source: true}
+	counted := make(map[string]bool)
+	layer := []string{source}
+
+	for len(layer) > 0 {
+		pages := wiki.LoadPages(ctx, bpf.pageLoader, layer, bpf.numScraperThreads)
+		if ctx.Err() != nil {
+			return histogram, nil
+		}
+
+		var layerTitles []string
+		var nextLayer []string
+		for _, page := range pages {
+			if page.Title == "" || counted[page.Title] {
+				continue
+			}
+			counted[page.Title] = true
+			layerTitles = append(layerTitles, page.Title)
+
+			for _, link := range page.Links {
+				if !visited[link] {
+					visited[link] = true
+					nextLayer = append(nextLayer, link)
+				}
+			}
+		}
+
+		if len(layerTitles) > 0 {
+			histogram.Layers = append(histogram.Layers, len(layerTitles))
+			histogram.Reached += len(layerTitles)
+
+			sort.Strings(layerTitles)
+			if len(layerTitles) > maxFarthestPages {
+				layerTitles = layerTitles[:maxFarthestPages]
+			}
+			histogram.Farthest = layerTitles
+		}
+
+		layer = nextLayer
+	}
+	histogram.Complete = true
+
+	if pageCounter, ok := bpf.pageLoader.(wiki.PageCounter); ok {
+		total, err := pageCounter.CountPages()
+		if err != nil {
+			return histogram, err
+		}
+		histogram.Unreachable = total - histogram.Reached
+	}
+
+	return histogram, nil
+}
@@ -2,85 +2,100 @@ package bfs
 
 import (
 	"context"
-	"errors"
 	"log"
+	"time"
 
 	"github.com/kbuzsaki/wikidegree/wiki"
 )
 
-// parallel implementation of bfs
-func (bpf *bfsPathFinder) findNearestPathParallel(ctx context.Context, start, end string) (wiki.TitlePath, error) {
-	titles := make(chan string, bpf.frontierSize)
-	pages := make(chan wiki.Page)
-
-	for i := 0; i < bpf.numScraperThreads; i++ {
-		go bpf.loadPages(ctx, titles, pages)
+// parallel implementation of bfs.
+// Each depth layer is loaded all at once by the pool of loaders, and only then
+// are the links of its pages visited, in layer order and then link order.
+// That way the path found doesn't depend on which pages happen to load first,
+// so it's always a shortest path and the same one from run to run.
+func (bpf *bfsPathFinder) findNearestPathParallel(ctx context.Context, starts []string, ends map[string]bool, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	visited := make(map[string]string)
+	var layer []string
+	for _, start := range starts {
+		if ends[start] {
+			return wiki.TitlePath{start}, nil
+		} else if _, ok := visited[start]; !ok {
+			visited[start] = ""
+			layer = append(layer, start)
+		}
 	}
 
-	titles <- start
-	visited := make(map[string]string)
-	visited[start] = ""
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, nil
-		case page, ok := <-pages:
-			if !ok {
-				return nil, nil
+	trace := wiki.ContextSearchTrace(ctx)
+
+	for depth := 0; len(layer) > 0; depth++ {
+		levelStart := time.Now()
+
+		pages := wiki.LoadPages(ctx, bpf.pageLoader, layer, bpf.numScraperThreads)
+		if ctx.Err() != nil {
+			return nil, wiki.CancelledError(ctx)
+		}
+
+		// a title in the layer may have turned out to redirect to the end
+		for _, page := range pages {
+			if depth > 0 && ends[page.Title] {
+				visited[page.Title] = visited[page.Redirector]
+				return pathFromVisited(visited, page.Title), nil
 			}
-			if page.Redirector != page.Title && len(visited[page.Title]) == 0 {
+		}
+
+		// the last layer is only loaded to check for redirects to the end
+		if !constraints.AllowsLinksAt(depth) {
+			trace.Level(wiki.LevelStats{Depth: depth, Frontier: len(layer), Elapsed: time.Since(levelStart)})
+			break
+		}
+
+		var nextLayer []string
+		for _, page := range pages {
+			// skip pages that failed to load
+			if page.Redirector == "" {
+				continue
+			}
+
+			// the resolved title of a redirect takes the place of the redirect itself
+			if _, ok := visited[page.Title]; !ok {
 				visited[page.Title] = visited[page.Redirector]
 			}
 
-			for _, link := range page.Links {
-				if link == end {
-					// close the channels to halt other goroutines
-					close(titles)
+			// a link may have led to a redirect that the constraints rule out
+			if depth > 0 && (!constraints.Allows(page.Title) || !constraints.AllowsLinksOf(page)) {
+				continue
+			}
+
+			for i, link := range page.Links {
+				if _, ok := visited[link]; ok || !constraints.AllowsLink(page, i) {
+					continue
+				}
 
-					log.Println("Found end page:", end, "stopping...")
+				if ends[link] {
+					log.Println("Found end page:", link)
 					visited[link] = page.Title
-					return pathFromVisited(visited, start, end), nil
-				} else if len(visited[link]) == 0 {
+					return pathFromVisited(visited, link), nil
+				} else if constraints.Allows(link) {
 					visited[link] = page.Title
-					titles <- link
+					nextLayer = append(nextLayer, link)
 				}
 			}
 		}
-	}
 
-	return nil, errors.New("Ran out of links!")
-}
-
-// simple function for loading pages from the loader
-func (bpf *bfsPathFinder) loadPages(ctx context.Context, titles <-chan string, pages chan<- wiki.Page) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case title, ok := <-titles:
-			if !ok {
-				return
-			}
-			log.Println("Loading page:", title)
-			if page, err := bpf.pageLoader.LoadPage(title); err == nil {
-				pages <- page
-			} else {
-				log.Println("Error loading page:", title, "error:", err)
-			}
-		}
+		trace.Level(wiki.LevelStats{Depth: depth, Frontier: len(layer), Elapsed: time.Since(levelStart)})
+		layer = nextLayer
 	}
+
+	return nil, noPathError(constraints)
 }
 
-func pathFromVisited(visited map[string]string, start string, end string) []string {
-	// starts from the end of the graph and pops back
-	var path []string
-	parent := end
-	for parent != start {
-		path = append(path, parent)
-		parent = visited[parent]
+func pathFromVisited(visited map[string]string, end string) wiki.TitlePath {
+	// starts from the end of the graph and pops back until it reaches a
+	// start, which are the only titles without a parent
+	var path wiki.TitlePath
+	for title := end; title != ""; title = visited[title] {
+		path = append(path, title)
 	}
-	path = append(path, start)
 
 	// reverse the path before returning
 	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
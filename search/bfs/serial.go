@@ -1,8 +1,9 @@
 package bfs
 
 import (
-	"errors"
+	"context"
 	"log"
+	"time"
 
 	"github.com/kbuzsaki/wikidegree/wiki"
 )
@@ -21,22 +22,63 @@ func (pathQueue *TitlePathQueue) Pop() wiki.TitlePath {
 }
 
 // serial implementation of bfs
-func (bpf *bfsPathFinder) findNearestPathSerial(start string, end string) (wiki.TitlePath, error) {
+func (bpf *bfsPathFinder) findNearestPathSerial(ctx context.Context, starts []string, ends map[string]bool, constraints wiki.Constraints) (wiki.TitlePath, error) {
 	visited := make(map[string]bool)
-	visited[start] = true
-	frontier := TitlePathQueue{{start}}
+	var frontier TitlePathQueue
+	for _, start := range starts {
+		if ends[start] {
+			return wiki.TitlePath{start}, nil
+		} else if !visited[start] {
+			visited[start] = true
+			frontier.Push(wiki.TitlePath{start})
+		}
+	}
+
+	// the queue holds every path of one length before any of the next, so a
+	// level is done as soon as a longer path comes off of it
+	trace := wiki.ContextSearchTrace(ctx)
+	level := wiki.LevelStats{}
+	levelStart := time.Now()
 
 	for len(frontier) > 0 {
 		titlePath := frontier.Pop()
 
-		log.Println("Loading page:", titlePath)
-		if page, err := bpf.pageLoader.LoadPage(titlePath.Head()); err == nil {
-			for _, title := range page.Links {
+		if depth := len(titlePath) - 1; depth != level.Depth {
+			level.Elapsed = time.Since(levelStart)
+			trace.Level(level)
+			level = wiki.LevelStats{Depth: depth}
+			levelStart = time.Now()
+		}
+		level.Frontier++
+
+		page, err := bpf.pageLoader.LoadPage(titlePath.Head())
+		trace.PageLoad(titlePath.Head(), err)
+		if err == nil {
+			// a link may have turned out to redirect to the end
+			if len(titlePath) > 1 && ends[page.Title] {
+				return titlePath[:len(titlePath)-1].Catted(page.Title), nil
+			}
+
+			// a link may have led to a redirect that the constraints rule out
+			if len(titlePath) > 1 && (!constraints.Allows(page.Title) || !constraints.AllowsLinksOf(page)) {
+				continue
+			}
+
+			// the last layer is only loaded to check for redirects to the end
+			if !constraints.AllowsLinksAt(len(titlePath) - 1) {
+				continue
+			}
+
+			for i, title := range page.Links {
+				if !constraints.AllowsLink(page, i) {
+					continue
+				}
+
 				newTitlePath := titlePath.Catted(title)
 
-				if title == end {
+				if ends[title] {
 					return newTitlePath, nil
-				} else if !visited[title] {
+				} else if !visited[title] && constraints.Allows(title) {
 					visited[title] = true
 					frontier.Push(newTitlePath)
 				}
@@ -46,5 +88,8 @@ func (bpf *bfsPathFinder) findNearestPathSerial(start string, end string) (wiki.
 		}
 	}
 
-	return nil, errors.New("Ran out of links!")
+	level.Elapsed = time.Since(levelStart)
+	trace.Level(level)
+
+	return nil, noPathError(constraints)
 }
@@ -0,0 +1,444 @@
+package bfs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// rough number of bytes that each title kept in memory costs on top of the
+// title itself, for map and slice bookkeeping
+const spillEntryOverhead = 64
+
+// the number of titles from a layer that are loaded at once
+const spillBatchSize = 10000
+
+// the number of visited titles written to disk per transaction
+const spillWriteBatchSize = 100000
+
+var visitedBucket = []byte("visited")
+
+// Returns a bfs path finder whose frontier and visited set are moved out to
+// temporary files once they use more than about memoryBudget bytes, so that
+// deep searches don't run out of memory. A budget of 0 means no limit.
+func GetBoundedBfsPathFinder(pageLoader wiki.PageLoader, memoryBudget int) wiki.ConstrainedPathFinder {
+	pathFinder := bfsPathFinder{pageLoader, defaultNumScraperThreads, false, memoryBudget}
+	return &pathFinder
+}
+
+// bfs that searches one layer at a time and spills to disk.
+// Half of the memory budget goes to the visited set and half to the next
+// layer of the search. The current layer is streamed back in from disk in
+// batches, so it doesn't need any room of its own.
+func (bpf *bfsPathFinder) findNearestPathSpilling(ctx context.Context, starts []string, ends map[string]bool, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	for _, start := range starts {
+		if ends[start] {
+			return wiki.TitlePath{start}, nil
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "wikidegree-bfs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	visited := newSpillingVisited(filepath.Join(dir, "visited.db"), bpf.memoryBudget/2)
+	defer visited.close()
+
+	layer := newSpillingLayer(dir, "layer0", bpf.memoryBudget/2)
+	starts, err = visited.unvisited(starts)
+	if err != nil {
+		return nil, err
+	}
+	for _, start := range starts {
+		if err := visited.visit(start, ""); err != nil {
+			return nil, err
+		}
+		if err := layer.add(start); err != nil {
+			return nil, err
+		}
+	}
+
+	trace := wiki.ContextSearchTrace(ctx)
+
+	for depth := 0; layer.length > 0; depth++ {
+		nextLayer := newSpillingLayer(dir, fmt.Sprint("layer", depth+1), bpf.memoryBudget/2)
+		levelStart := time.Now()
+		found := ""
+
+		err := layer.forEachBatch(spillBatchSize, func(titles []string) error {
+			pages := wiki.LoadPages(ctx, bpf.pageLoader, titles, bpf.numScraperThreads)
+			if ctx.Err() != nil {
+				return wiki.CancelledError(ctx)
+			}
+
+			// a title in the batch may have turned out to redirect to the end
+			for _, page := range pages {
+				if depth > 0 && ends[page.Title] {
+					parent, _, err := visited.parent(page.Redirector)
+					if err != nil {
+						return err
+					}
+					if err := visited.visit(page.Title, parent); err != nil {
+						return err
+					}
+					found = page.Title
+					return errStopSearch
+				}
+			}
+
+			// the last layer is only loaded to check for redirects to the end
+			if !constraints.AllowsLinksAt(depth) {
+				return nil
+			}
+
+			for _, page := range pages {
+				if page.Redirector == "" {
+					continue
+				}
+
+				// the resolved title of a redirect takes the place of the redirect itself
+				if page.Title != page.Redirector {
+					if _, ok, err := visited.parent(page.Title); err != nil {
+						return err
+					} else if !ok {
+						parent, _, err := visited.parent(page.Redirector)
+						if err != nil {
+							return err
+						}
+						if err := visited.visit(page.Title, parent); err != nil {
+							return err
+						}
+					}
+				}
+
+				// a link may have led to a redirect that the constraints rule out
+				if depth > 0 && (!constraints.Allows(page.Title) || !constraints.AllowsLinksOf(page)) {
+					continue
+				}
+
+				var candidates []string
+				for i, link := range page.Links {
+					if !constraints.AllowsLink(page, i) {
+						continue
+					}
+					if ends[link] || constraints.Allows(link) {
+						candidates = append(candidates, link)
+					}
+				}
+
+				links, err := visited.unvisited(candidates)
+				if err != nil {
+					return err
+				}
+
+				for _, link := range links {
+					if err := visited.visit(link, page.Title); err != nil {
+						return err
+					}
+					if ends[link] {
+						found = link
+						return errStopSearch
+					}
+					if err := nextLayer.add(link); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		})
+
+		layer.remove()
+		if found != "" {
+			nextLayer.remove()
+			return visited.path(found)
+		}
+		if err != nil {
+			nextLayer.remove()
+			return nil, err
+		}
+
+		trace.Level(wiki.LevelStats{Depth: depth, Frontier: layer.length, Elapsed: time.Since(levelStart)})
+		layer = nextLayer
+	}
+
+	return nil, noPathError(constraints)
+}
+
+// returned from inside of a batch to stop going through the layer
+var errStopSearch = errors.New("stop search")
+
+// The titles that the search has visited and the title that each was reached
+// from. Entries are kept in a map until they use up the budget, at which point
+// they're all moved into a temporary bolt db.
+type spillingVisited struct {
+	filename string
+	budget   int
+	used     int
+	entries  map[string]string
+
+	// nil until the first time that the entries are spilled
+	db *bolt.DB
+}
+
+func newSpillingVisited(filename string, budget int) *spillingVisited {
+	return &spillingVisited{filename: filename, budget: budget, entries: make(map[string]string)}
+}
+
+// Returns the title that the given title was reached from, and whether it has
+// been visited at all
+func (sv *spillingVisited) parent(title string) (string, bool, error) {
+	if parent, ok := sv.entries[title]; ok {
+		return parent, true, nil
+	}
+	if sv.db == nil {
+		return "", false, nil
+	}
+
+	var parent []byte
+	err := sv.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(visitedBucket).Get([]byte(title)); value != nil {
+			parent = append([]byte{}, value...)
+		}
+		return nil
+	})
+
+	return string(parent), parent != nil, err
+}
+
+// Returns the titles that haven't been visited, without any duplicates
+func (sv *spillingVisited) unvisited(titles []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, title := range titles {
+		if _, ok := sv.entries[title]; !ok && !seen[title] {
+			seen[title] = true
+			result = append(result, title)
+		}
+	}
+	if sv.db == nil || len(result) == 0 {
+		return result, nil
+	}
+
+	// check everything that's left against the disk in a single transaction
+	filtered := result[:0]
+	err := sv.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(visitedBucket)
+		for _, title := range result {
+			if bucket.Get([]byte(title)) == nil {
+				filtered = append(filtered, title)
+			}
+		}
+		return nil
+	})
+
+	return filtered, err
+}
+
+func (sv *spillingVisited) visit(title, parent string) error {
+	sv.entries[title] = parent
+	sv.used += len(title) + len(parent) + spillEntryOverhead
+
+	if sv.budget > 0 && sv.used > sv.budget {
+		return sv.spill()
+	}
+	return nil
+}
+
+// Moves every entry in memory into the db
+func (sv *spillingVisited) spill() error {
+	if sv.db == nil {
+		db, err := bolt.Open(sv.filename, 0600, &bolt.Options{NoGrowSync: true})
+		if err != nil {
+			return err
+		}
+		sv.db = db
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(visitedBucket)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	titles := make([]string, 0, len(sv.entries))
+	for title := range sv.entries {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	for len(titles) > 0 {
+		batch := titles
+		if len(batch) > spillWriteBatchSize {
+			batch = batch[:spillWriteBatchSize]
+		}
+		titles = titles[len(batch):]
+
+		err := sv.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(visitedBucket)
+			for _, title := range batch {
+				if err := bucket.Put([]byte(title), []byte(sv.entries[title])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sv.entries = make(map[string]string)
+	sv.used = 0
+	return nil
+}
+
+// Follows the parents from end back to a start
+func (sv *spillingVisited) path(end string) (wiki.TitlePath, error) {
+	var path wiki.TitlePath
+	for title := end; title != ""; {
+		path = append(path, title)
+
+		parent, ok, err := sv.parent(title)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errors.New("No parent recorded for title '" + title + "'")
+		}
+		title = parent
+	}
+
+	path.Reverse()
+
+	return path, nil
+}
+
+func (sv *spillingVisited) close() error {
+	if sv.db != nil {
+		return sv.db.Close()
+	}
+	return nil
+}
+
+// One layer of the search. Titles are kept in memory until they use up the
+// budget, at which point they're written out to a new segment file.
+type spillingLayer struct {
+	dir      string
+	name     string
+	budget   int
+	used     int
+	titles   []string
+	segments []string
+	length   int
+}
+
+func newSpillingLayer(dir, name string, budget int) *spillingLayer {
+	return &spillingLayer{dir: dir, name: name, budget: budget}
+}
+
+func (sl *spillingLayer) add(title string) error {
+	sl.titles = append(sl.titles, title)
+	sl.used += len(title) + spillEntryOverhead
+	sl.length++
+
+	if sl.budget > 0 && sl.used > sl.budget {
+		return sl.spill()
+	}
+	return nil
+}
+
+// Writes the titles in memory out to a new segment, one per line
+func (sl *spillingLayer) spill() error {
+	filename := filepath.Join(sl.dir, fmt.Sprintf("%s-%d", sl.name, len(sl.segments)))
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, title := range sl.titles {
+		writer.WriteString(title)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	sl.segments = append(sl.segments, filename)
+	sl.titles = nil
+	sl.used = 0
+	return nil
+}
+
+// Calls fn with batches of up to size titles, first from the segments on
+// disk and then from memory, until fn returns an error.
+func (sl *spillingLayer) forEachBatch(size int, fn func(titles []string) error) error {
+	for _, filename := range sl.segments {
+		if err := forEachSegmentBatch(filename, size, fn); err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(sl.titles); start += size {
+		end := start + size
+		if end > len(sl.titles) {
+			end = len(sl.titles)
+		}
+		if err := fn(sl.titles[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func forEachSegmentBatch(filename string, size int, fn func(titles []string) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	batch := make([]string, 0, size)
+	for scanner.Scan() {
+		batch = append(batch, scanner.Text())
+		if len(batch) == size {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// Deletes the layer's segment files and drops its titles
+func (sl *spillingLayer) remove() {
+	for _, filename := range sl.segments {
+		os.Remove(filename)
+	}
+	sl.segments = nil
+	sl.titles = nil
+}
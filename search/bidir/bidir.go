@@ -0,0 +1,202 @@
+/*
+Implements a "Six degrees of separation" style search for the shortest "path"
+between two wikipedia pages using a bidirectional breadth first search.
+
+The search expands forwards from the start page along its links and backwards
+from the end page along its backlinks, one whole depth layer at a time, and
+stops as soon as the two sides meet in the middle. Because each side only has
+to go about half of the distance, the number of pages explored is usually
+orders of magnitude smaller than with the one sided search in search/bfs.
+
+The side with the smaller frontier is always the one that gets expanded,
+which keeps the search from drowning in the links of hub pages.
+
+This requires a page loader that also implements wiki.BacklinkLoader.
+Redirects are only resolved once a page is actually loaded, so a path that
+goes through a redirect may occasionally be found one layer late.
+*/
+package bidir
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+const defaultNumLoaderThreads = 10
+
+func GetBidirPathFinder(pageLoader wiki.PageLoader) wiki.PathFinder {
+	pathFinder := bidirPathFinder{pageLoader, defaultNumLoaderThreads}
+	return &pathFinder
+}
+
+// Implements wiki.PathFinder
+type bidirPathFinder struct {
+	pageLoader       wiki.PageLoader
+	numLoaderThreads int
+}
+
+// Implements wiki.PathFinder.SetPageLoader()
+func (bpf *bidirPathFinder) SetPageLoader(pageLoader wiki.PageLoader) {
+	bpf.pageLoader = pageLoader
+}
+
+// Implements wiki.PathFinder.FindPath()
+func (bpf *bidirPathFinder) FindPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
+	path, err := bpf.findPath(ctx, start, end)
+	wiki.ContextSearchTrace(ctx).Done(len(path) - 1)
+	return path, err
+}
+
+// Each round of the search is reported to the search trace as a level, with
+// the number of links explored so far between the two sides as its depth.
+func (bpf *bidirPathFinder) findPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
+	backlinkLoader, ok := bpf.pageLoader.(wiki.BacklinkLoader)
+	if !ok {
+		return nil, errors.New("Page loader does not support backlinks")
+	}
+
+	if start == end {
+		return wiki.TitlePath{start}, nil
+	}
+
+	forward := newSide(start)
+	backward := newSide(end)
+
+	backlinkPages := backlinkPageLoader{backlinkLoader}
+
+	trace := wiki.ContextSearchTrace(ctx)
+
+	for depth := 0; len(forward.frontier) > 0 && len(backward.frontier) > 0; depth++ {
+		var meeting string
+		var found bool
+		var frontierSize int
+		levelStart := time.Now()
+
+		// expand whichever side has less work to do
+		if len(forward.frontier) <= len(backward.frontier) {
+			frontierSize = len(forward.frontier)
+			pages := wiki.LoadPages(ctx, bpf.pageLoader, forward.frontier, bpf.numLoaderThreads)
+			meeting, found = forward.expand(pages, backward)
+		} else {
+			frontierSize = len(backward.frontier)
+			pages := wiki.LoadPages(ctx, backlinkPages, backward.frontier, bpf.numLoaderThreads)
+			meeting, found = backward.expand(pages, forward)
+		}
+
+		if ctx.Err() != nil {
+			return nil, wiki.CancelledError(ctx)
+		}
+		trace.Level(wiki.LevelStats{Depth: depth, Frontier: frontierSize, Elapsed: time.Since(levelStart)})
+		if found {
+			log.Println("Sides met at:", meeting)
+			return joinPath(forward, backward, meeting), nil
+		}
+	}
+
+	return nil, wiki.ErrNoPath
+}
+
+// Loads a title's backlinks as though they were the links of its page, so that
+// the backward side of the search can be expanded the same way as the forward
+// side. Implements wiki.PageLoader
+type backlinkPageLoader struct {
+	backlinkLoader wiki.BacklinkLoader
+}
+
+func (bpl backlinkPageLoader) LoadPage(title string) (wiki.Page, error) {
+	backlinks, err := bpl.backlinkLoader.LoadBacklinks(title)
+	return wiki.Page{Redirector: title, Title: title, Links: backlinks}, err
+}
+
+func (bpl backlinkPageLoader) Close() error {
+	return nil
+}
+
+// A title that has been reached by one side of the search
+type node struct {
+	// the title that this one was reached from,
+	// or "" if this is the title that the side started from
+	parent string
+	depth  int
+}
+
+// One direction of the search
+type side struct {
+	visited  map[string]node
+	frontier []string
+}
+
+func newSide(title string) *side {
+	visited := map[string]node{title: {}}
+	return &side{visited, []string{title}}
+}
+
+// Visits all of the neighbors of the pages from the current frontier and
+// replaces the frontier with the newly visited titles.
+// If any of the new titles have been visited by the other side, returns
+// the one that makes for the shortest combined path.
+func (s *side) expand(pages []wiki.Page, other *side) (string, bool) {
+	var nextFrontier []string
+	var meeting string
+	var meetingDepth int
+	found := false
+
+	visit := func(title string, n node) {
+		s.visited[title] = n
+
+		if otherNode, ok := other.visited[title]; ok {
+			if !found || n.depth+otherNode.depth < meetingDepth {
+				meeting = title
+				meetingDepth = n.depth + otherNode.depth
+				found = true
+			}
+		}
+	}
+
+	for _, page := range pages {
+		if page.Redirector == "" {
+			continue
+		}
+		current := s.visited[page.Redirector]
+
+		// the resolved title of a redirect is the same place as the redirect
+		// itself, so it doesn't need to be loaded again
+		if _, ok := s.visited[page.Title]; !ok {
+			visit(page.Title, current)
+		}
+
+		for _, link := range page.Links {
+			if _, ok := s.visited[link]; !ok {
+				visit(link, node{page.Redirector, current.depth + 1})
+				nextFrontier = append(nextFrontier, link)
+			}
+		}
+	}
+
+	s.frontier = nextFrontier
+	return meeting, found
+}
+
+// Follows the forward side's parents back to the start and the backward
+// side's parents on to the end.
+func joinPath(forward, backward *side, meeting string) wiki.TitlePath {
+	var path wiki.TitlePath
+	for title := meeting; title != ""; title = forward.visited[title].parent {
+		path = append(path, title)
+	}
+
+	// reverse the first half of the path
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	for title := backward.visited[meeting].parent; title != ""; title = backward.visited[title].parent {
+		path = append(path, title)
+	}
+
+	return path
+}
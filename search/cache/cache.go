@@ -0,0 +1,67 @@
+/*
+Implements a path finder that remembers the paths it has found in a
+wiki.PathCache, so that popular pairs of pages don't need to be searched for
+over and over again.
+
+Paths are kept under the version of the index that they were found in and
+the algorithm that found them, so a rebuilt index never serves paths from the
+old one and a weighted search never serves paths found by an unweighted one.
+Paths from old versions of the index just age out of the cache.
+*/
+package cache
+
+import (
+	"context"
+	"log"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// Wraps the path finder so that its paths are saved to and loaded from the
+// path cache. The index version should come from the page loader that the
+// path finder uses, through wiki.IndexVersioner.
+func GetCachedPathFinder(pathFinder wiki.PathFinder, pathCache wiki.PathCache, indexVersion, algorithm string) wiki.PathFinder {
+	return &cachedPathFinder{pathFinder, pathCache, indexVersion, algorithm}
+}
+
+// Implements wiki.PathFinder
+type cachedPathFinder struct {
+	pathFinder   wiki.PathFinder
+	pathCache    wiki.PathCache
+	indexVersion string
+	algorithm    string
+}
+
+// Implements wiki.PathFinder.SetPageLoader()
+// The page loader must read from the same version of the index.
+func (cpf *cachedPathFinder) SetPageLoader(pageLoader wiki.PageLoader) {
+	cpf.pathFinder.SetPageLoader(pageLoader)
+}
+
+// Implements wiki.PathFinder.FindPath()
+// A path found in the cache is reported to the search trace as a cache hit.
+// The cache only speeds searches up, so errors from it are logged rather than
+// returned.
+func (cpf *cachedPathFinder) FindPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
+	key := wiki.PathKey{Index: cpf.indexVersion, Algorithm: cpf.algorithm, Start: start, End: end}
+
+	path, ok, err := cpf.pathCache.LoadPath(key)
+	if err != nil {
+		log.Println("Error loading path from cache:", err)
+	} else if ok {
+		trace := wiki.ContextSearchTrace(ctx)
+		trace.CacheHit()
+		trace.Done(len(path) - 1)
+		return path, nil
+	}
+
+	path, err = cpf.pathFinder.FindPath(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cpf.pathCache.SavePath(key, path); err != nil {
+		log.Println("Error saving path to cache:", err)
+	}
+	return path, nil
+}
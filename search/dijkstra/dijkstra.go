@@ -0,0 +1,172 @@
+/*
+Implements a search for the "lightest" path between two wikipedia pages using
+Dijkstra's algorithm.
+
+Rather than counting every link the same, each link has a weight that was
+recorded when the index was built, based on where the link is on its page.
+Links in the lead section of an article are cheap and links in footnotes or
+in the "References" section are expensive, so the path found is the one that
+sticks to links that are most about their pages' subjects, even if it takes a
+hop or two more than the shortest path.
+
+Pages loaded from an index without weights count every link the same, which
+makes this a (slower) breadth first search.
+*/
+package dijkstra
+
+import (
+	"container/heap"
+	"context"
+	"log"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+func GetDijkstraPathFinder(pageLoader wiki.PageLoader) wiki.PathFinder {
+	pathFinder := dijkstraPathFinder{pageLoader}
+	return &pathFinder
+}
+
+// Implements wiki.PathFinder
+type dijkstraPathFinder struct {
+	pageLoader wiki.PageLoader
+}
+
+// Implements wiki.PathFinder.SetPageLoader()
+func (dpf *dijkstraPathFinder) SetPageLoader(pageLoader wiki.PageLoader) {
+	dpf.pageLoader = pageLoader
+}
+
+// Implements wiki.PathFinder.FindPath()
+// Pages are loaded one at a time in order of their distance from the start,
+// so the first time that the end comes off the queue its path is the lightest.
+func (dpf *dijkstraPathFinder) FindPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
+	path, err := dpf.findLightestPath(ctx, start, end)
+	wiki.ContextSearchTrace(ctx).Done(len(path) - 1)
+	return path, err
+}
+
+func (dpf *dijkstraPathFinder) findLightestPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
+	distances := map[string]int{start: 0}
+	parents := map[string]string{start: ""}
+	settled := make(map[string]bool)
+
+	queue := &distanceQueue{}
+	queue.push(start, 0)
+
+	trace := wiki.ContextSearchTrace(ctx)
+
+	for queue.Len() > 0 {
+		if ctx.Err() != nil {
+			return nil, wiki.CancelledError(ctx)
+		}
+
+		item := queue.pop()
+		if settled[item.title] {
+			continue
+		}
+		settled[item.title] = true
+
+		if item.title == end {
+			log.Println("Found end page:", end, "weight:", item.distance)
+			return pathFromParents(parents, end), nil
+		}
+
+		page, err := dpf.pageLoader.LoadPage(item.title)
+		trace.PageLoad(item.title, err)
+		if err != nil {
+			log.Println("Error loading page:", item.title, "error:", err)
+			continue
+		}
+
+		// the resolved title of a redirect takes the place of the redirect itself
+		if page.Title != item.title {
+			if settled[page.Title] {
+				continue
+			}
+			settled[page.Title] = true
+			parents[page.Title] = parents[item.title]
+			distances[page.Title] = item.distance
+
+			if page.Title == end {
+				log.Println("Found end page:", end, "weight:", item.distance)
+				return pathFromParents(parents, end), nil
+			}
+		}
+
+		for i, link := range page.Links {
+			distance := item.distance + page.LinkWeight(i)
+			if previous, ok := distances[link]; !ok || distance < previous {
+				distances[link] = distance
+				parents[link] = page.Title
+				queue.push(link, distance)
+			}
+		}
+	}
+
+	return nil, wiki.ErrNoPath
+}
+
+func pathFromParents(parents map[string]string, end string) wiki.TitlePath {
+	// starts from the end of the graph and pops back until it reaches the
+	// start, which is the only title without a parent
+	var path wiki.TitlePath
+	for title := end; title != ""; title = parents[title] {
+		path = append(path, title)
+	}
+
+	path.Reverse()
+
+	return path
+}
+
+type distanceItem struct {
+	title    string
+	distance int
+	order    int
+}
+
+// A min heap of titles by their distance from the start.
+// Titles at the same distance come off in the order that they were pushed,
+// so that the same search always finds the same path.
+// A title is pushed again whenever a shorter way to it is found, and the
+// stale entries are skipped when they're popped.
+type distanceQueue struct {
+	items  []distanceItem
+	pushes int
+}
+
+func (dq *distanceQueue) push(title string, distance int) {
+	heap.Push(dq, distanceItem{title, distance, dq.pushes})
+	dq.pushes++
+}
+
+func (dq *distanceQueue) pop() distanceItem {
+	return heap.Pop(dq).(distanceItem)
+}
+
+// Implements heap.Interface
+func (dq *distanceQueue) Len() int {
+	return len(dq.items)
+}
+
+func (dq *distanceQueue) Less(i, j int) bool {
+	if dq.items[i].distance != dq.items[j].distance {
+		return dq.items[i].distance < dq.items[j].distance
+	}
+	return dq.items[i].order < dq.items[j].order
+}
+
+func (dq *distanceQueue) Swap(i, j int) {
+	dq.items[i], dq.items[j] = dq.items[j], dq.items[i]
+}
+
+func (dq *distanceQueue) Push(x interface{}) {
+	dq.items = append(dq.items, x.(distanceItem))
+}
+
+func (dq *distanceQueue) Pop() interface{} {
+	item := dq.items[len(dq.items)-1]
+	dq.items = dq.items[:len(dq.items)-1]
+	return item
+}
@@ -17,27 +17,41 @@ nuclear_chemistry
 vladimir_putin
 
 This algorithm is less friendly to parallelization than the breadth first
-search, but it should give much better memory efficiency at the cost of
-additioanl cpu and network.
+search, but it uses much less memory at the cost of additional cpu and
+network. Rather than keeping every page that it has reached like the bfs, it
+only keeps the paths waiting to be searched, which grow with the depth limit
+and the number of links per page, and a table of the shallowest depth that up
+to maxRememberedDepths titles were reached at. The table saves searching the
+same title again from the same depth, and once it's full later titles are
+just searched again whenever they're reached, like a plain iddfs.
 */
 package iddfs
 
 import (
 	"context"
 	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/kbuzsaki/wikidegree/wiki"
 )
 
 const defaultMaxWorkerThreads = 10
+
+// the deepest that a search goes when its constraints don't set a max depth
 const defaultMaxDepth = 4
 
-func GetIddfsPathFinder(pageLoader wiki.PageLoader) wiki.PathFinder {
-	pathFinder := iddfsPathFinder{pageLoader, defaultMaxWorkerThreads, defaultMaxDepth, true}
+// the most titles that each depth limited search remembers the depth of,
+// which keeps its memory bounded however much of the graph it reaches
+const maxRememberedDepths = 1 << 20
+
+func GetIddfsPathFinder(pageLoader wiki.PageLoader) wiki.ConstrainedPathFinder {
+	pathFinder := iddfsPathFinder{pageLoader, defaultMaxWorkerThreads, defaultMaxDepth, false}
 	return &pathFinder
 }
 
-// Implements wiki.PathFinder
+// Implements wiki.ConstrainedPathFinder
 type iddfsPathFinder struct {
 	pageLoader       wiki.PageLoader
 	maxWorkerThreads int
@@ -52,121 +66,253 @@ func (ipf *iddfsPathFinder) SetPageLoader(pageLoader wiki.PageLoader) {
 
 // Implements wiki.PathFinder.FindPath()
 func (ipf *iddfsPathFinder) FindPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
-	var path wiki.TitlePath
+	return ipf.FindConstrainedPath(ctx, start, end, wiki.Constraints{})
+}
+
+// Implements wiki.ConstrainedPathFinder.FindConstrainedPath()
+// The search goes up to constraints.MaxDepth links deep, or the finder's own
+// max depth if that isn't set, and fails with wiki.ErrNoPath past that.
+func (ipf *iddfsPathFinder) FindConstrainedPath(ctx context.Context, start, end string, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	maxDepth := ipf.maxDepth
+	if constraints.MaxDepth > 0 {
+		maxDepth = constraints.MaxDepth
+	}
 
+	ends := ipf.loadEnds(end)
+
+	var path wiki.TitlePath
 	if ipf.serial {
-		path = ipf.findNearestPathSerial(start, end)
+		path = ipf.findNearestPathSerial(ctx, start, ends, maxDepth, constraints)
 	} else {
-		path = ipf.findNearestPathParallel(start, end)
+		path = ipf.findNearestPathParallel(ctx, start, ends, maxDepth, constraints)
+	}
+	if path != nil {
+		// the path may have reached the end through one of its redirects
+		path[len(path)-1] = end
+	}
+	wiki.ContextSearchTrace(ctx).Done(len(path) - 1)
+
+	if ctx.Err() != nil {
+		return nil, wiki.CancelledError(ctx)
+	}
+	if path == nil {
+		return nil, fmt.Errorf("%w within %d links", wiki.ErrNoPath, maxDepth)
 	}
 
 	return path, nil
 }
 
-func (ipf *iddfsPathFinder) findNearestPathParallel(start string, end string) wiki.TitlePath {
-	// iterative deepening parallel currently doesn't work,
-	// the program will deadlock once there is nothing left to read
-	//
-	// NOTE: that means this algorithm is *NOT* currently guaranteed
-	//       to find the shortest path from start to end
-	//
-	/*
-		for depthLimit := 2; depthLimit <= MaxDepth; depthLimit++ {
-			fmt.Println()
-			fmt.Println("Beginning search with depth limit", depthLimit)
-			path := depthLimitedSearchParallel(start, end, depthLimit)
-
-			if path != nil {
-				return path
-			}
+// Returns the titles that count as reaching the end: the end itself and, if
+// the page loader knows them, the titles that redirect to it.
+// The bfs finds links through redirects by loading them, but that would mean
+// loading every page at the depth limit here, which is most of the search.
+func (ipf *iddfsPathFinder) loadEnds(end string) map[string]bool {
+	ends := map[string]bool{end: true}
+
+	if redirectLoader, ok := ipf.pageLoader.(wiki.RedirectLoader); ok {
+		redirects, err := redirectLoader.LoadRedirects(end)
+		if err != nil {
+			log.Println("Error loading redirects to:", end, "error:", err)
+		}
+		for _, redirect := range redirects {
+			ends[redirect] = true
 		}
-	*/
+	}
 
-	// just do a regular depth limited search instead
-	return ipf.depthLimitedSearchParallel(start, end, ipf.maxDepth)
+	return ends
 }
 
-func (ipf *iddfsPathFinder) depthLimitedSearchParallel(start string, end string, depthLimit int) wiki.TitlePath {
-	requestQueue := make(chan chan<- wiki.TitlePath)
-	loadedQueue := make(chan wiki.TitlePath)
-	toLoadQueue := make(chan wiki.TitlePath)
-
-	go DfsQueue(toLoadQueue, requestQueue)
-
-	for i := 0; i < ipf.maxWorkerThreads; i++ {
-		go ipf.requestQueueWorker(requestQueue, loadedQueue)
+// Runs a complete parallel depth limited search for each depth limit in turn.
+// Because every shallower depth limit has been searched exhaustively before a
+// deeper one is started, the first path found is always a shortest path.
+// Each depth limit is reported to the search trace as a level, with the
+// number of pages that were expanded as its frontier.
+func (ipf *iddfsPathFinder) findNearestPathParallel(ctx context.Context, start string, ends map[string]bool, maxDepth int, constraints wiki.Constraints) wiki.TitlePath {
+	if ends[start] {
+		return wiki.TitlePath{start}
 	}
 
-	toLoadQueue <- wiki.TitlePath{start}
+	trace := wiki.ContextSearchTrace(ctx)
+
+	for depthLimit := 1; depthLimit <= maxDepth; depthLimit++ {
+		log.Println("Beginning search with depth limit", depthLimit)
+		levelStart := time.Now()
+		path, expanded := ipf.depthLimitedSearchParallel(ctx, start, ends, depthLimit, constraints)
+		trace.Level(wiki.LevelStats{Depth: depthLimit, Frontier: expanded, Elapsed: time.Since(levelStart)})
 
-	for titlePath := range loadedQueue {
-		if titlePath.Head() == end {
-			return titlePath
-		} else if len(titlePath) <= depthLimit {
-			toLoadQueue <- titlePath
+		if path != nil || ctx.Err() != nil {
+			return path
 		}
 	}
 
 	return nil
 }
 
-func (ipf *iddfsPathFinder) requestQueueWorker(requestQueue chan<- chan<- wiki.TitlePath, output chan<- wiki.TitlePath) {
-	input := make(chan wiki.TitlePath)
-
-	for {
-		requestQueue <- input
-		titlePath := <-input
+// Searches every path of up to depthLimit links from start using a pool of
+// workers that share a DfsQueue. Returns once a path to end is found, the
+// queue runs out of paths, or the context is cancelled, along with the number
+// of pages that were expanded.
+func (ipf *iddfsPathFinder) depthLimitedSearchParallel(ctx context.Context, start string, ends map[string]bool, depthLimit int, constraints wiki.Constraints) (wiki.TitlePath, int) {
+	queue := NewDfsQueue()
+	queue.Push(wiki.TitlePath{start})
+
+	// stop handing out work if the search is cancelled
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			queue.Close()
+		case <-finished:
+		}
+	}()
 
-		fmt.Println("Loading:", titlePath)
-		page, _ := ipf.pageLoader.LoadPage(titlePath.Head())
+	// reaching a title again at the same depth or deeper can't lead anywhere new
+	depths := newDepthTable(start)
+	var result wiki.TitlePath
+	var expanded int
+	var lock sync.Mutex
+	trace := wiki.ContextSearchTrace(ctx)
 
-		for _, link := range page.Links {
-			newTitlePath := titlePath.Catted(link)
-			output <- newTitlePath
-		}
+	wg := &sync.WaitGroup{}
+	wg.Add(ipf.maxWorkerThreads)
+	for i := 0; i < ipf.maxWorkerThreads; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				titlePath, ok := queue.Pop()
+				if !ok {
+					return
+				}
+
+				page, err := ipf.pageLoader.LoadPage(titlePath.Head())
+				trace.PageLoad(titlePath.Head(), err)
+				if err != nil {
+					log.Println("Error loading page:", titlePath.Head(), "error:", err)
+				} else if !allowsPage(constraints, titlePath, page) {
+					page.Links = nil
+				}
+
+				lock.Lock()
+				expanded++
+				for i, link := range page.Links {
+					if !constraints.AllowsLink(page, i) {
+						continue
+					}
+
+					newTitlePath := titlePath.Catted(link)
+					depth := len(newTitlePath) - 1
+
+					if ends[link] {
+						if result == nil {
+							result = newTitlePath
+						}
+						queue.Close()
+						break
+					} else if depth < depthLimit && constraints.Allows(link) && depths.reach(link, depth) {
+						queue.Push(newTitlePath)
+					}
+				}
+				lock.Unlock()
+
+				queue.Done()
+			}
+		}()
 	}
+	wg.Wait()
+
+	return result, expanded
 }
 
-func (ipf *iddfsPathFinder) findNearestPathSerial(start string, end string) wiki.TitlePath {
-	for depthLimit := 1; depthLimit <= ipf.maxDepth; depthLimit++ {
+func (ipf *iddfsPathFinder) findNearestPathSerial(ctx context.Context, start string, ends map[string]bool, maxDepth int, constraints wiki.Constraints) wiki.TitlePath {
+	if ends[start] {
+		return wiki.TitlePath{start}
+	}
+
+	trace := wiki.ContextSearchTrace(ctx)
+
+	for depthLimit := 1; depthLimit <= maxDepth; depthLimit++ {
 		fmt.Println()
 		fmt.Println("Beginning search with depth limit", depthLimit)
-		path := ipf.depthLimitedSearchSerial(start, end, depthLimit)
+		levelStart := time.Now()
+		path, expanded := ipf.depthLimitedSearchSerial(ctx, start, ends, depthLimit, constraints)
+		trace.Level(wiki.LevelStats{Depth: depthLimit, Frontier: expanded, Elapsed: time.Since(levelStart)})
 
-		if path != nil {
+		if path != nil || ctx.Err() != nil {
 			return path
 		}
 	}
 	return nil
 }
 
-func (ipf *iddfsPathFinder) depthLimitedSearchSerial(start string, end string, depthLimit int) wiki.TitlePath {
-	// technically this isn't needed anymore,
-	// since a depth limited search will handle cycles gracefully
-	visited := make(map[string]bool)
-	visited[start] = true
+func (ipf *iddfsPathFinder) depthLimitedSearchSerial(ctx context.Context, start string, ends map[string]bool, depthLimit int, constraints wiki.Constraints) (wiki.TitlePath, int) {
+	// a title can be reached by a deep path before a shallow one, so it has to
+	// be searched again if a shallower path to it turns up later
+	depths := newDepthTable(start)
 
 	var titlePath wiki.TitlePath
 	titlePathStack := []wiki.TitlePath{{start}}
+	expanded := 0
+	trace := wiki.ContextSearchTrace(ctx)
 
-	for len(titlePathStack) > 0 {
+	for len(titlePathStack) > 0 && ctx.Err() == nil {
 		titlePath, titlePathStack = titlePathStack[len(titlePathStack)-1], titlePathStack[:len(titlePathStack)-1]
 
-		fmt.Println("Loading:", titlePath)
-		page, _ := ipf.pageLoader.LoadPage(titlePath.Head())
+		page, err := ipf.pageLoader.LoadPage(titlePath.Head())
+		trace.PageLoad(titlePath.Head(), err)
+		expanded++
+		if !allowsPage(constraints, titlePath, page) {
+			continue
+		}
+
+		for i, link := range page.Links {
+			if !constraints.AllowsLink(page, i) {
+				continue
+			}
 
-		for _, link := range page.Links {
 			newTitlePath := titlePath.Catted(link)
-			if link == end {
+			if ends[link] {
 				fmt.Println("Done!")
 				fmt.Println()
-				return newTitlePath
-			} else if len(newTitlePath) <= depthLimit && !visited[link] {
-				visited[link] = true
+				return newTitlePath, expanded
+			} else if depth := len(newTitlePath) - 1; depth < depthLimit && constraints.Allows(link) && depths.reach(link, depth) {
 				titlePathStack = append(titlePathStack, newTitlePath)
 			}
 		}
 	}
 
-	return nil
+	return nil, expanded
+}
+
+// The shallowest depth that titles have been reached at in a depth limited
+// search, for up to maxRememberedDepths titles
+type depthTable map[string]int
+
+func newDepthTable(start string) depthTable {
+	return depthTable{start: 0}
+}
+
+// Records that the title was reached at the depth, and returns whether it
+// should be searched from there. It should unless it's already been reached
+// at the same depth or shallower.
+func (dt depthTable) reach(title string, depth int) bool {
+	if previous, ok := dt[title]; ok {
+		if depth >= previous {
+			return false
+		}
+		dt[title] = depth
+	} else if len(dt) < maxRememberedDepths {
+		dt[title] = depth
+	}
+	return true
+}
+
+// Whether the search may follow the links of the page at the head of the path.
+// The start page is always allowed, but a link may have led to a redirect that
+// the constraints rule out.
+func allowsPage(constraints wiki.Constraints, titlePath wiki.TitlePath, page wiki.Page) bool {
+	if len(titlePath) == 1 {
+		return true
+	}
+	return constraints.Allows(page.Title) && constraints.AllowsLinksOf(page)
 }
@@ -7,35 +7,80 @@ package iddfs
 import (
 	"container/heap"
 	"fmt"
+	"sync"
 
 	"github.com/kbuzsaki/wikidegree/wiki"
 )
 
-func DfsQueue(input <-chan wiki.TitlePath, requests <-chan chan<- wiki.TitlePath) {
-	// internal channel variable that will be nil if the pqueue is empty
-	// this lets us avoid taking requests when we're unable to fulfill them
-	var internalRequests <-chan chan<- wiki.TitlePath
-	pqueue := make(titlePathQueue, 0)
+// A priority queue of TitlePaths shared between the workers of a depth limited
+// search. Longer paths are popped first so that the search stays depth first
+// and the queue stays small.
+//
+// The queue also keeps track of how many of the paths pushed to it haven't
+// been finished yet, either because they are still waiting in the queue or
+// because a worker is still loading them. Once that count drops to zero there
+// is no more work left to hand out, so every waiting Pop returns.
+type DfsQueue struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	pqueue  titlePathQueue
+	pending int
+	closed  bool
+}
+
+func NewDfsQueue() *DfsQueue {
+	dq := &DfsQueue{pqueue: make(titlePathQueue, 0)}
+	dq.cond = sync.NewCond(&dq.lock)
+	return dq
+}
 
-	for {
-		select {
-		// receive a TitlePath, add it to the queue
-		case titlePath := <-input:
-			fmt.Println("Pushing:", titlePath)
-			pqueue.push(titlePath)
-			if internalRequests == nil {
-				internalRequests = requests
-			}
-		// receive a request for a TitlePath, pop it off the queue
-		case request := <-internalRequests:
-			titlePath := pqueue.pop()
-			fmt.Println("Popping:", titlePath)
-			request <- titlePath
-			if len(pqueue) == 0 {
-				internalRequests = nil
-			}
-		}
+// Adds a TitlePath to the queue
+func (dq *DfsQueue) Push(titlePath wiki.TitlePath) {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	dq.pqueue.push(titlePath)
+	dq.pending++
+	dq.cond.Signal()
+}
+
+// Removes the longest TitlePath from the queue, waiting for one to be pushed
+// if the queue is empty but other paths are still being worked on.
+// Returns false once the queue is closed or there is no work left.
+// Every successful Pop must be followed by a call to Done.
+func (dq *DfsQueue) Pop() (wiki.TitlePath, bool) {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	for len(dq.pqueue) == 0 && dq.pending > 0 && !dq.closed {
+		dq.cond.Wait()
 	}
+
+	if dq.closed || len(dq.pqueue) == 0 {
+		return nil, false
+	}
+
+	return dq.pqueue.pop(), true
+}
+
+// Marks a popped TitlePath as finished
+func (dq *DfsQueue) Done() {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	dq.pending--
+	if dq.pending == 0 {
+		dq.cond.Broadcast()
+	}
+}
+
+// Stops the queue from handing out any more TitlePaths
+func (dq *DfsQueue) Close() {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	dq.closed = true
+	dq.cond.Broadcast()
 }
 
 func TestPQueue() {
@@ -76,7 +121,7 @@ func TestPQueue() {
 //
 // The type isn't exported because it's an implementation detail.
 // Anyone wanting to use this should go through the
-// DfsQueue function and communicate using channels
+// DfsQueue type, which is safe for concurrent use
 type titlePathQueue []wiki.TitlePath
 
 // The methods that I actually care about for the DfsQueue
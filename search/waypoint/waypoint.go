@@ -0,0 +1,79 @@
+/*
+Implements routing through an ordered list of wikipedia pages.
+
+A waypoint path from A to D via B and C is made up of a shortest path from A
+to B, then one from B to C, then one from C to D, joined together. Each leg
+is only shortest on its own, so the whole path may be longer than the
+shortest path that happens to pass through the same pages.
+
+When the path is required to be distinct, each leg avoids every page used by
+the legs before it along with the waypoints that are still to come, so that
+no page shows up in the path twice.
+*/
+package waypoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// Finds a path from the first waypoint to the last that passes through each
+// of the waypoints in between in order.
+// Distinct paths and non-empty constraints need a wiki.ConstrainedPathFinder.
+func FindPath(ctx context.Context, pathFinder wiki.PathFinder, waypoints []string, constraints wiki.Constraints, distinct bool) (wiki.TitlePath, error) {
+	if len(waypoints) < 2 {
+		return nil, errors.New("At least 2 waypoints are required")
+	}
+
+	constrainedPathFinder, constrained := pathFinder.(wiki.ConstrainedPathFinder)
+	if (distinct || !constraints.IsEmpty()) && !constrained {
+		return nil, errors.New("Constraints are not supported by this path finder")
+	}
+
+	// copy the avoided titles so that the caller's constraints aren't modified
+	avoid := make(map[string]bool)
+	for title := range constraints.Avoid {
+		avoid[title] = true
+	}
+	constraints.Avoid = avoid
+
+	path := wiki.TitlePath{waypoints[0]}
+
+	for i := 1; i < len(waypoints); i++ {
+		start, end := waypoints[i-1], waypoints[i]
+
+		if distinct {
+			for _, title := range waypoints[i+1:] {
+				avoid[title] = true
+			}
+		}
+
+		var leg wiki.TitlePath
+		var err error
+		if constrained {
+			leg, err = constrainedPathFinder.FindConstrainedPath(ctx, start, end, constraints)
+		} else {
+			leg, err = pathFinder.FindPath(ctx, start, end)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Leg from '%s' to '%s': %w", start, end, err)
+		}
+		if len(leg) == 0 {
+			return nil, fmt.Errorf("Leg from '%s' to '%s': %w", start, end, wiki.ErrNoPath)
+		}
+
+		// the start of each leg is the end of the one before it
+		path = append(path, leg[1:]...)
+
+		if distinct {
+			for _, title := range leg {
+				avoid[title] = true
+			}
+		}
+	}
+
+	return path, nil
+}
@@ -0,0 +1,266 @@
+/*
+Implements a search for the K shortest loopless paths between two wikipedia
+pages using Yen's algorithm.
+
+The first path is found with an ordinary breadth first search. Each later
+path is found by taking every prefix ("root") of the previous path, banning
+the links that the paths found so far take out of the root's last page
+("spur"), banning the rest of the root's pages so that the path can't loop
+back through them, and searching for the shortest path from the spur to the
+end page. The shortest of all of those candidates becomes the next path.
+
+Every candidate search starts over from scratch, so the pages that have been
+loaded are kept around for the rest of the search. This trades a good deal of
+memory for not hitting the page loader over and over for the same pages.
+
+For more info, see the wikipedia article:
+https://en.wikipedia.org/wiki/Yen%27s_algorithm
+*/
+package yen
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+const defaultNumLoaderThreads = 10
+
+func GetYenPathFinder(pageLoader wiki.PageLoader) wiki.KPathFinder {
+	pathFinder := yenPathFinder{pageLoader, defaultNumLoaderThreads}
+	return &pathFinder
+}
+
+// Implements wiki.KPathFinder
+type yenPathFinder struct {
+	pageLoader       wiki.PageLoader
+	numLoaderThreads int
+}
+
+// Implements wiki.KPathFinder.SetPageLoader()
+func (ypf *yenPathFinder) SetPageLoader(pageLoader wiki.PageLoader) {
+	ypf.pageLoader = pageLoader
+}
+
+// Implements wiki.KPathFinder.FindKPaths()
+// The start and end may be redirects, but the paths only hold the titles that
+// they resolve to.
+func (ypf *yenPathFinder) FindKPaths(ctx context.Context, start, end string, k int) ([]wiki.TitlePath, error) {
+	cache := &linkCache{ypf.pageLoader, ypf.numLoaderThreads, make(map[string]string), make(map[string][]string)}
+
+	cache.loadLayer(ctx, []string{start, end})
+	if ctx.Err() != nil {
+		return nil, wiki.CancelledError(ctx)
+	}
+	start, end = cache.titles[start], cache.titles[end]
+
+	first, err := cache.shortestPath(ctx, start, end, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if first == nil {
+		return nil, wiki.ErrNoPath
+	}
+
+	paths := []wiki.TitlePath{first}
+	var candidates []wiki.TitlePath
+
+	for len(paths) < k {
+		previous := paths[len(paths)-1]
+
+		for i := 0; i < len(previous)-1; i++ {
+			spur := previous[i]
+			root := previous[:i+1]
+
+			// don't take the same first step as any path that shares this root
+			bannedLinks := make(map[string]bool)
+			for _, path := range paths {
+				if len(path) > i+1 && hasPrefix(path, root) {
+					bannedLinks[path[i+1]] = true
+				}
+			}
+
+			// and don't loop back through the root
+			bannedTitles := make(map[string]bool)
+			for _, title := range root[:i] {
+				bannedTitles[title] = true
+			}
+
+			spurPath, err := cache.shortestPath(ctx, spur, end, bannedTitles, bannedLinks)
+			if err != nil {
+				return nil, err
+			}
+			if spurPath == nil {
+				continue
+			}
+
+			candidate := make(wiki.TitlePath, 0, i+len(spurPath))
+			candidate = append(candidate, root[:i]...)
+			candidate = append(candidate, spurPath...)
+			if !containsPath(candidates, candidate) && !containsPath(paths, candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		// the shortest candidate is the next path, with ties broken by title
+		// so that the results are deterministic
+		sort.Slice(candidates, func(i, j int) bool {
+			if len(candidates[i]) != len(candidates[j]) {
+				return len(candidates[i]) < len(candidates[j])
+			}
+			return strings.Join(candidates[i], "\n") < strings.Join(candidates[j], "\n")
+		})
+		paths = append(paths, candidates[0])
+		candidates = candidates[1:]
+	}
+
+	return paths, nil
+}
+
+// Remembers the links of every page loaded during a search
+type linkCache struct {
+	pageLoader       wiki.PageLoader
+	numLoaderThreads int
+
+	// the title that each loaded title resolved to, which is itself unless
+	// it's a redirect
+	titles map[string]string
+
+	// the links of each resolved title
+	links map[string][]string
+}
+
+// Finds the shortest path from start to end with a layered breadth first
+// search that never visits the banned titles and never follows the banned
+// links out of the start page. start and end must be resolved titles.
+// Links are only visited once they've been loaded and resolved, so that a
+// redirect can't lead the path back to a page that it has already been
+// through, or to one that's banned.
+// Returns a nil path if there is no such path.
+func (lc *linkCache) shortestPath(ctx context.Context, start, end string, bannedTitles, bannedLinks map[string]bool) (wiki.TitlePath, error) {
+	// the resolved titles reached so far, each with the title it was reached from
+	visited := make(map[string]string)
+
+	// the titles that each link in the layer was found on, in the order that
+	// they were found
+	linkedFrom := map[string][]string{start: {""}}
+	layer := []string{start}
+
+	for len(layer) > 0 {
+		lc.loadLayer(ctx, layer)
+		if ctx.Err() != nil {
+			return nil, wiki.CancelledError(ctx)
+		}
+
+		var titles []string
+		for _, link := range layer {
+			title := lc.titles[link]
+			if _, ok := visited[title]; ok || bannedTitles[title] {
+				continue
+			}
+
+			for _, parent := range linkedFrom[link] {
+				if parent == start && bannedLinks[title] {
+					continue
+				}
+
+				visited[title] = parent
+				if title == end {
+					return pathFromVisited(visited, end), nil
+				}
+				titles = append(titles, title)
+				break
+			}
+		}
+
+		linkedFrom = make(map[string][]string)
+		var nextLayer []string
+		for _, title := range titles {
+			for _, link := range lc.links[title] {
+				if _, ok := visited[link]; ok || bannedTitles[link] || (title == start && bannedLinks[link]) {
+					continue
+				}
+
+				// a link straight to the end doesn't need to be resolved
+				if link == end {
+					visited[end] = title
+					return pathFromVisited(visited, end), nil
+				}
+
+				if _, ok := linkedFrom[link]; !ok {
+					nextLayer = append(nextLayer, link)
+				}
+				linkedFrom[link] = append(linkedFrom[link], title)
+			}
+		}
+
+		layer = nextLayer
+	}
+
+	return nil, nil
+}
+
+// Loads the links of every title that isn't already cached using a pool of
+// loader goroutines. Pages that fail to load are cached as having no links.
+func (lc *linkCache) loadLayer(ctx context.Context, titles []string) {
+	var uncached []string
+	for _, title := range titles {
+		if _, ok := lc.titles[title]; !ok {
+			uncached = append(uncached, title)
+		}
+	}
+
+	pages := wiki.LoadPages(ctx, lc.pageLoader, uncached, lc.numLoaderThreads)
+
+	if ctx.Err() != nil {
+		return
+	}
+	for index, title := range uncached {
+		page := pages[index]
+		if page.Redirector == "" {
+			lc.titles[title] = title
+			continue
+		}
+
+		lc.titles[title] = page.Title
+		lc.links[page.Title] = page.Links
+	}
+}
+
+func pathFromVisited(visited map[string]string, end string) wiki.TitlePath {
+	var path wiki.TitlePath
+	for title := end; title != ""; title = visited[title] {
+		path = append(path, title)
+	}
+
+	path.Reverse()
+
+	return path
+}
+
+func hasPrefix(path, prefix wiki.TitlePath) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPath(paths []wiki.TitlePath, path wiki.TitlePath) bool {
+	for _, p := range paths {
+		if len(p) == len(path) && hasPrefix(p, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package logic
+
+import (
+	"context"
+	"time"
+
+	"github.com/kbuzsaki/wikidegree/wiki"
+)
+
+// the number of lookups in a batch that run at the same time
+const batchConcurrency = 4
+
+// One of the lookups in a batch
+type PathRequest struct {
+	Start string
+	End   string
+}
+
+// The result of one of the lookups in a batch, with either the path or the
+// error that the lookup failed with
+type PathResult struct {
+	Start string
+	End   string
+	Path  wiki.TitlePath
+	Err   error
+	Time  time.Duration
+
+	// true if the path came from the path cache
+	Cached bool
+}
+
+// Looks up the path for every request, a few at a time, and returns the
+// results in the same order as the requests.
+// Lookups from the same batch tend to pass through a lot of the same pages,
+// which the page cache keeps around between them. Each one gets the timeout
+// on its own, but they all stop if ctx is done.
+func (l *logicImpl) LookupPaths(ctx context.Context, requests []PathRequest, constraints wiki.Constraints, timeout time.Duration) []PathResult {
+	results := make([]PathResult, len(requests))
+
+	wiki.ForEachIndex(len(requests), batchConcurrency, func(index int) {
+		results[index] = l.lookupBatchPath(ctx, requests[index], constraints, timeout)
+	})
+
+	return results
+}
+
+func (l *logicImpl) lookupBatchPath(ctx context.Context, request PathRequest, constraints wiki.Constraints, timeout time.Duration) PathResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	recorder := wiki.NewStatsRecorder()
+	ctx = wiki.WithSearchTrace(ctx, recorder.Trace())
+
+	startTime := time.Now()
+	path, err := l.LookupPath(ctx, request.Start, request.End, constraints)
+
+	return PathResult{Start: request.Start, End: request.End, Path: path, Err: err, Time: time.Since(startTime), Cached: recorder.Stats().Cached}
+}
@@ -3,49 +3,274 @@ package logic
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"time"
 
+	"github.com/kbuzsaki/wikidegree/graph"
+	"github.com/kbuzsaki/wikidegree/search/alt"
 	"github.com/kbuzsaki/wikidegree/search/bfs"
+	"github.com/kbuzsaki/wikidegree/search/bidir"
+	"github.com/kbuzsaki/wikidegree/search/cache"
+	"github.com/kbuzsaki/wikidegree/search/dijkstra"
+	"github.com/kbuzsaki/wikidegree/search/iddfs"
+	"github.com/kbuzsaki/wikidegree/search/waypoint"
 	"github.com/kbuzsaki/wikidegree/wiki"
 )
 
 type Logic interface {
-	LookupPath(ctx context.Context, start, end string) (wiki.TitlePath, error)
+	LookupPath(ctx context.Context, start, end string, constraints wiki.Constraints) (wiki.TitlePath, error)
+	LookupWaypointPath(ctx context.Context, waypoints []string, constraints wiki.Constraints, distinct bool) (wiki.TitlePath, error)
+	LookupMultiPath(ctx context.Context, starts, ends []string, constraints wiki.Constraints) (wiki.TitlePath, error)
+	LookupCategoryPath(ctx context.Context, starts []string, category string, constraints wiki.Constraints) (wiki.TitlePath, error)
+	LookupAllPaths(ctx context.Context, start, end string) (wiki.PathDAG, error)
 	LookupPage(ctx context.Context, title string) (wiki.Page, error)
+	LookupBacklinks(ctx context.Context, title string) ([]string, error)
+	LookupHistogram(ctx context.Context, title string) (wiki.DistanceHistogram, error)
+	LookupDistances(ctx context.Context, source string, targets []string, constraints wiki.Constraints) ([]wiki.TargetDistance, error)
+	ExplainPath(ctx context.Context, path wiki.TitlePath) ([]wiki.Hop, error)
+	LookupPaths(ctx context.Context, requests []PathRequest, constraints wiki.Constraints, timeout time.Duration) []PathResult
+	LookupPageCacheStats(ctx context.Context) (wiki.PageCacheStats, error)
 }
 
 type logicImpl struct {
-	pageLoader wiki.PageLoader
-	pathFinder wiki.PathFinder
+	pageLoader      wiki.PageLoader
+	pathFinder      wiki.PathFinder
+	allPathsFinder  wiki.AllPathsFinder
+	histogramFinder wiki.HistogramFinder
+	distancesFinder wiki.DistancesFinder
+
+	// nil if there's no text index to explain paths with
+	textLoader wiki.TextLoader
+
+	// nil if paths aren't being cached
+	pathCache    wiki.PathCache
+	indexVersion string
+	algorithm    string
 }
 
-func New() (Logic, error) {
-	pageLoader, err := wiki.GetBoltPageLoader()
+func New(source, algorithm string) (Logic, error) {
+	pageLoader, err := getPageLoader(source)
 	if err != nil {
 		return nil, err
 	}
-	pathFinder := bfs.GetBfsPathFinder(pageLoader)
+	pathFinder, err := getPathFinder(algorithm, pageLoader)
+	if err != nil {
+		pageLoader.Close()
+		return nil, err
+	}
+	allPathsFinder := bfs.GetBfsAllPathsFinder(pageLoader)
+	histogramFinder := bfs.GetBfsHistogramFinder(pageLoader)
+	distancesFinder := bfs.GetBfsDistancesFinder(pageLoader)
+
+	// the text index is optional, so paths just can't be explained without it
+	textLoader, err := wiki.GetBoltTextLoader()
+	if err != nil {
+		log.Println("Paths can't be explained without a text index:", err)
+	}
+
+	pathCache, indexVersion := openPathCache(pageLoader)
 
-	return &logicImpl{pageLoader, pathFinder}, nil
+	return &logicImpl{pageLoader, pathFinder, allPathsFinder, histogramFinder, distancesFinder, textLoader, pathCache, indexVersion, algorithm}, nil
 }
 
-func (l *logicImpl) LookupPath(ctx context.Context, start, end string) (wiki.TitlePath, error) {
-	startPage, err := l.LookupPage(ctx, start)
+// Opens the cache of paths that have been found before. The cache is optional,
+// so paths are just searched for every time if it can't be opened, or if the
+// page loader can't say which version of the index its pages are from.
+func openPathCache(pageLoader wiki.PageLoader) (wiki.PathCache, string) {
+	indexVersioner, ok := pageLoader.(wiki.IndexVersioner)
+	if !ok {
+		log.Println("Paths won't be cached since the page source has no index version")
+		return nil, ""
+	}
+	indexVersion, err := indexVersioner.IndexVersion()
+	if err != nil {
+		log.Println("Paths won't be cached without an index version:", err)
+		return nil, ""
+	}
+
+	pathCache, err := wiki.GetBoltPathCache(wiki.DefaultPathCacheName, wiki.DefaultPathCacheSize)
+	if err != nil {
+		log.Println("Paths won't be cached without a path cache:", err)
+		return nil, ""
+	}
+
+	return pathCache, indexVersion
+}
+
+func getPageLoader(source string) (wiki.PageLoader, error) {
+	switch source {
+	case "bolt":
+		pageLoader, err := wiki.GetBoltPageLoader()
+		if err != nil {
+			return nil, err
+		}
+		// hub pages come up in nearly every search, so keep them decoded
+		return wiki.GetCachingPageLoader(pageLoader, wiki.DefaultPageCacheBudget), nil
+	case "graph":
+		return graph.LoadFile(graph.DefaultSnapshotName)
+	default:
+		return nil, errors.New("unknown page source: " + source)
+	}
+}
+
+func getPathFinder(algorithm string, pageLoader wiki.PageLoader) (wiki.PathFinder, error) {
+	switch algorithm {
+	case "bfs":
+		return bfs.GetBfsPathFinder(pageLoader), nil
+	case "iddfs":
+		return iddfs.GetIddfsPathFinder(pageLoader), nil
+	case "bidir":
+		return bidir.GetBidirPathFinder(pageLoader), nil
+	case "dijkstra":
+		return dijkstra.GetDijkstraPathFinder(pageLoader), nil
+	case "alt":
+		table, err := alt.OpenLandmarkTable(alt.DefaultLandmarksName)
+		if err != nil {
+			return nil, err
+		}
+		return alt.GetAltPathFinder(pageLoader, table), nil
+	default:
+		return nil, errors.New("unknown path finding algorithm: " + algorithm)
+	}
+}
+
+func (l *logicImpl) LookupPath(ctx context.Context, start, end string, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	start, end, err := l.lookupEndpoints(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Finding path from '" + start + "' to '" + end + "'")
+	if constraints.IsEmpty() {
+		// only paths without constraints are cached, since they're the ones
+		// that get looked up over and over
+		if l.pathCache != nil {
+			return cache.GetCachedPathFinder(l.pathFinder, l.pathCache, l.indexVersion, l.algorithm).FindPath(ctx, start, end)
+		}
+		return l.pathFinder.FindPath(ctx, start, end)
+	}
+
+	constrainedPathFinder, ok := l.pathFinder.(wiki.ConstrainedPathFinder)
+	if !ok {
+		return nil, errors.New("constraints not supported by this algorithm")
+	}
+	return constrainedPathFinder.FindConstrainedPath(ctx, start, end, constraints)
+}
+
+func (l *logicImpl) LookupWaypointPath(ctx context.Context, waypoints []string, constraints wiki.Constraints, distinct bool) (wiki.TitlePath, error) {
+	titles := make([]string, len(waypoints))
+	for i, waypoint := range waypoints {
+		page, err := l.LookupPage(ctx, waypoint)
+		if err != nil {
+			return nil, err
+		}
+		if i < len(waypoints)-1 && len(page.Links) == 0 {
+			return nil, &wiki.PageError{Title: page.Title, Err: wiki.ErrNoLinks}
+		}
+
+		// use the page titles instead of the user input in case there were redirects
+		titles[i] = page.Title
+	}
+
+	log.Println("Finding path through", titles)
+	return waypoint.FindPath(ctx, l.pathFinder, titles, constraints, distinct)
+}
+
+func (l *logicImpl) LookupMultiPath(ctx context.Context, starts, ends []string, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	multiPathFinder, ok := l.pathFinder.(wiki.MultiPathFinder)
+	if !ok {
+		return nil, errors.New("multiple start or end pages not supported by this algorithm")
+	}
+
+	startTitles, err := l.lookupTitles(ctx, starts)
+	if err != nil {
+		return nil, err
+	}
+	endTitles, err := l.lookupTitles(ctx, ends)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Finding path from any of", startTitles, "to any of", endTitles)
+	return multiPathFinder.FindMultiPath(ctx, startTitles, endTitles, constraints)
+}
+
+// Finds the shortest path from any of the starts to any page in the category
+func (l *logicImpl) LookupCategoryPath(ctx context.Context, starts []string, category string, constraints wiki.Constraints) (wiki.TitlePath, error) {
+	categoryLoader, ok := l.pageLoader.(wiki.CategoryLoader)
+	if !ok {
+		return nil, errors.New("categories not supported")
+	}
+	multiPathFinder, ok := l.pathFinder.(wiki.MultiPathFinder)
+	if !ok {
+		return nil, errors.New("category search not supported by this algorithm")
+	}
+
+	category = wiki.CategoryTitle(category)
+	if category == "" {
+		return nil, errors.New("category required")
+	}
+
+	members, err := categoryLoader.LoadCategoryMembers(category)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("%w: '%s' has no pages", wiki.ErrNoPath, category)
+	}
+
+	startTitles, err := l.lookupTitles(ctx, starts)
 	if err != nil {
 		return nil, err
 	}
+
+	// the members come straight from the index, so they don't need resolving
+	log.Println("Finding path from any of", startTitles, "to any page in", category)
+	return multiPathFinder.FindMultiPath(ctx, startTitles, members, constraints)
+}
+
+// Looks up each of the pages and returns their titles, with redirects resolved
+func (l *logicImpl) lookupTitles(ctx context.Context, titles []string) ([]string, error) {
+	resolved := make([]string, len(titles))
+	for i, title := range titles {
+		page, err := l.LookupPage(ctx, title)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = page.Title
+	}
+
+	return resolved, nil
+}
+
+func (l *logicImpl) LookupAllPaths(ctx context.Context, start, end string) (wiki.PathDAG, error) {
+	start, end, err := l.lookupEndpoints(ctx, start, end)
+	if err != nil {
+		return wiki.PathDAG{}, err
+	}
+
+	log.Println("Finding all paths from '" + start + "' to '" + end + "'")
+	return l.allPathsFinder.FindAllPaths(ctx, start, end)
+}
+
+// Validates the start and end pages of a path lookup and returns their titles
+func (l *logicImpl) lookupEndpoints(ctx context.Context, start, end string) (string, string, error) {
+	startPage, err := l.LookupPage(ctx, start)
+	if err != nil {
+		return "", "", err
+	}
 	if len(startPage.Links) == 0 {
-		return nil, errors.New("start page has no links!")
+		return "", "", &wiki.PageError{Title: startPage.Title, Err: wiki.ErrNoLinks}
 	}
 
 	endPage, err := l.LookupPage(ctx, end)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
 	// use the page titles instead of the user input in case there were redirects
-	log.Println("Finding path from '" + startPage.Title + "' to '" + endPage.Title + "'")
-	return l.pathFinder.FindPath(ctx, startPage.Title, endPage.Title)
+	return startPage.Title, endPage.Title, nil
 }
 
 func (l *logicImpl) LookupPage(ctx context.Context, title string) (wiki.Page, error) {
@@ -56,3 +281,88 @@ func (l *logicImpl) LookupPage(ctx context.Context, title string) (wiki.Page, er
 
 	return l.pageLoader.LoadPage(title)
 }
+
+func (l *logicImpl) LookupBacklinks(ctx context.Context, title string) ([]string, error) {
+	backlinkLoader, ok := l.pageLoader.(wiki.BacklinkLoader)
+	if !ok {
+		return nil, errors.New("backlinks not supported")
+	}
+
+	if title == "" {
+		return nil, errors.New("title required")
+	}
+	title = wiki.NormalizeTitle(title)
+
+	return backlinkLoader.LoadBacklinks(title)
+}
+
+func (l *logicImpl) LookupHistogram(ctx context.Context, title string) (wiki.DistanceHistogram, error) {
+	page, err := l.LookupPage(ctx, title)
+	if err != nil {
+		return wiki.DistanceHistogram{}, err
+	}
+
+	log.Println("Finding distance histogram from '" + page.Title + "'")
+	return l.histogramFinder.FindHistogram(ctx, page.Title)
+}
+
+// Finds how far each of the targets is from the source in a single search.
+// Each target is looked up on its own, so one that doesn't exist is reported
+// with its error in its own distance instead of failing the rest.
+func (l *logicImpl) LookupDistances(ctx context.Context, source string, targets []string, constraints wiki.Constraints) ([]wiki.TargetDistance, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("at least one target required")
+	}
+
+	page, err := l.LookupPage(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	distances := make([]wiki.TargetDistance, len(targets))
+	var targetTitles []string
+	for i, target := range targets {
+		targetPage, err := l.LookupPage(ctx, target)
+		if err != nil {
+			distances[i] = wiki.TargetDistance{Target: target, Distance: -1, Err: err}
+			continue
+		}
+		targetTitles = append(targetTitles, targetPage.Title)
+	}
+	if len(targetTitles) == 0 {
+		return distances, nil
+	}
+
+	log.Println("Finding distances from '"+page.Title+"' to", len(targetTitles), "targets")
+	found, err := l.distancesFinder.FindDistances(ctx, page.Title, targetTitles, constraints)
+	if found == nil {
+		return nil, err
+	}
+
+	// the found distances are in the same order as the targets that exist
+	for i := range distances {
+		if distances[i].Err == nil {
+			distances[i], found = found[0], found[1:]
+		}
+	}
+	return distances, err
+}
+
+// Returns how well the cache of loaded pages is doing
+func (l *logicImpl) LookupPageCacheStats(ctx context.Context) (wiki.PageCacheStats, error) {
+	cachingPageLoader, ok := l.pageLoader.(wiki.CachingPageLoader)
+	if !ok {
+		return wiki.PageCacheStats{}, errors.New("pages aren't cached for this source")
+	}
+
+	return cachingPageLoader.CacheStats(), nil
+}
+
+// Explains each link in the path with where it is in the text of its page
+func (l *logicImpl) ExplainPath(ctx context.Context, path wiki.TitlePath) ([]wiki.Hop, error) {
+	if l.textLoader == nil {
+		return nil, errors.New("paths can't be explained without a text index")
+	}
+
+	return wiki.ExplainPath(l.textLoader, l.pageLoader, path)
+}
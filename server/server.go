@@ -4,24 +4,53 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kbuzsaki/wikidegree/server/logic"
+	"github.com/kbuzsaki/wikidegree/wiki"
 )
 
+// how long a path lookup may run before it is abandoned
+const lookupTimeout = 5 * time.Second
+
+// how long a histogram lookup may run before returning what it has so far
+const histogramTimeout = 60 * time.Second
+
+// the maximum number of paths returned by HandleAllPathsLookup by default
+const defaultPathsLimit = 100
+
+// the most paths that HandleAllPathsLookup can be asked to return
+const maxPathsLimit = 10000
+
+// the most pairs that HandleBatchPathLookup takes in one request
+const maxBatchSize = 1000
+
+// the most targets that HandleDistancesLookup takes in one request
+const maxDistanceTargets = 1000
+
 type Server interface {
 	HandlePathLookup(writer http.ResponseWriter, request *http.Request)
+	HandleAllPathsLookup(writer http.ResponseWriter, request *http.Request)
+	HandleBatchPathLookup(writer http.ResponseWriter, request *http.Request)
 	HandlePageLookup(writer http.ResponseWriter, request *http.Request)
+	HandleBacklinksLookup(writer http.ResponseWriter, request *http.Request)
+	HandleHistogramLookup(writer http.ResponseWriter, request *http.Request)
+	HandleDistancesLookup(writer http.ResponseWriter, request *http.Request)
+	HandlePageCacheLookup(writer http.ResponseWriter, request *http.Request)
 }
 
 type serverImpl struct {
 	logic logic.Logic
 }
 
-func New() (Server, error) {
-	l, err := logic.New()
+func New(source, algorithm string) (Server, error) {
+	l, err := logic.New(source, algorithm)
 	if err != nil {
 		return nil, err
 	}
@@ -34,25 +63,182 @@ func (s *serverImpl) HandlePathLookup(writer http.ResponseWriter, request *http.
 	start := values.Get("start")
 	end := values.Get("end")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// start and end may each be repeated to search from any of several pages
+	// to any of several others
+	starts := values["start"]
+	ends := values["end"]
+
+	constraints, err := parseConstraints(values)
+	if err != nil {
+		s.renderError(writer, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	recorder := wiki.NewStatsRecorder()
+	ctx = wiki.WithSearchTrace(ctx, recorder.Trace())
+
+	// pages to pass through on the way from start to end, in order
+	via := values["via"]
+
+	// a category to search for any page in, instead of a specific end page
+	category := values.Get("category")
+
+	if (len(via) != 0 && (len(starts) > 1 || len(ends) > 1)) || (category != "" && (len(via) != 0 || len(ends) != 0)) {
+		s.renderError(writer, errors.New("only one of via, category, or multiple start or end pages may be used"))
+		return
+	}
+	if len(via) != 0 && constraints.MaxDepth != 0 {
+		s.renderError(writer, errors.New("maxdepth can't be combined with via"))
+		return
+	}
+
+	startTime := time.Now()
+	var path wiki.TitlePath
+	if category != "" {
+		path, err = s.logic.LookupCategoryPath(ctx, starts, category, constraints)
+	} else if len(starts) > 1 || len(ends) > 1 {
+		path, err = s.logic.LookupMultiPath(ctx, starts, ends, constraints)
+	} else if len(via) == 0 {
+		path, err = s.logic.LookupPath(ctx, start, end, constraints)
+	} else {
+		waypoints := append(append([]string{start}, via...), end)
+		distinct := values.Get("distinct") != ""
+		path, err = s.logic.LookupWaypointPath(ctx, waypoints, constraints, distinct)
+	}
+	duration := time.Since(startTime)
+
+	// with a max depth, running out of pages means that the end definitely
+	// can't be reached in that many links, which is an answer and not an error
+	if constraints.MaxDepth != 0 && errors.Is(err, wiki.ErrNoPath) {
+		s.renderJSON(writer, map[string]interface{}{
+			"time":      duration.String(),
+			"reachable": false,
+			"maxdepth":  constraints.MaxDepth,
+			"stats":     recorder.Stats(),
+		})
+	} else if err != nil {
+		s.renderLookupError(writer, err, lookupTimeout)
+	} else {
+		stats := recorder.Stats()
+		resp := map[string]interface{}{
+			"time":   duration.String(),
+			"path":   path,
+			"cached": stats.Cached,
+			"stats":  stats,
+		}
+		if constraints.MaxDepth != 0 {
+			resp["reachable"] = true
+			resp["maxdepth"] = constraints.MaxDepth
+		}
+		// the path is still worth having if it can't be explained
+		if values.Get("explain") != "" {
+			hops, err := s.logic.ExplainPath(ctx, path)
+			if err != nil {
+				resp["explainError"] = err.Error()
+			} else {
+				resp["hops"] = hops
+			}
+		}
+		s.renderJSON(writer, resp)
+	}
+}
+
+func (s *serverImpl) HandleAllPathsLookup(writer http.ResponseWriter, request *http.Request) {
+	values := request.URL.Query()
+	start := values.Get("start")
+	end := values.Get("end")
+
+	limit := defaultPathsLimit
+	if values.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(values.Get("limit"))
+		if err != nil || limit < 1 {
+			s.renderError(writer, errors.New("limit must be a positive number"))
+			return
+		}
+	}
+	if limit > maxPathsLimit {
+		s.renderError(writer, fmt.Errorf("at most %d paths may be returned at once", maxPathsLimit))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
 	defer cancel()
 
 	startTime := time.Now()
-	path, err := s.logic.LookupPath(ctx, start, end)
+	dag, err := s.logic.LookupAllPaths(ctx, start, end)
 	duration := time.Since(startTime)
 
 	if err != nil {
-		s.renderError(writer, err)
-	} else if ctx.Err() != nil {
-		s.renderError(writer, errors.New("Timed out after 10 seconds."))
+		s.renderLookupError(writer, err, lookupTimeout)
 	} else {
 		s.renderJSON(writer, map[string]interface{}{
-			"time": duration.String(),
-			"path": path,
+			"time":  duration.String(),
+			"count": dag.Count(),
+			"paths": dag.Paths(limit),
 		})
 	}
 }
 
+// Looks up the path for each of a list of start and end pairs, which are
+// posted as json like {"pairs": [{"start": "Bubble_gum", "end": "Vladimir_Putin"}]}.
+// Constraints are taken from the url and apply to every pair.
+func (s *serverImpl) HandleBatchPathLookup(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		s.renderError(writer, errors.New("pairs must be posted as json"))
+		return
+	}
+
+	var body struct {
+		Pairs []logic.PathRequest
+	}
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		s.renderError(writer, errors.New("invalid json: "+err.Error()))
+		return
+	}
+	if len(body.Pairs) > maxBatchSize {
+		s.renderError(writer, fmt.Errorf("at most %d pairs may be looked up at once", maxBatchSize))
+		return
+	}
+
+	constraints, err := parseConstraints(request.URL.Query())
+	if err != nil {
+		s.renderError(writer, err)
+		return
+	}
+
+	// stop working on the batch if the client goes away
+	startTime := time.Now()
+	results := s.logic.LookupPaths(request.Context(), body.Pairs, constraints, lookupTimeout)
+	duration := time.Since(startTime)
+
+	rendered := make([]map[string]interface{}, len(results))
+	for i, result := range results {
+		rendered[i] = map[string]interface{}{
+			"start": result.Start,
+			"end":   result.End,
+			"time":  result.Time.String(),
+		}
+		if result.Err != nil {
+			rendered[i]["error"] = lookupErrorMessage(result.Err, lookupTimeout)
+			rendered[i]["code"] = errorCode(result.Err)
+		} else {
+			rendered[i]["path"] = result.Path
+			rendered[i]["cached"] = result.Cached
+		}
+	}
+
+	s.renderJSON(writer, map[string]interface{}{
+		"time":    duration.String(),
+		"results": rendered,
+	})
+}
+
+// Renders the page as it's stored in the index. Its links are only in the
+// order that they appear on the page for legacy indexes, see wiki.Page.
 func (s *serverImpl) HandlePageLookup(writer http.ResponseWriter, request *http.Request) {
 	values := request.URL.Query()
 	title := values.Get("title")
@@ -65,11 +251,204 @@ func (s *serverImpl) HandlePageLookup(writer http.ResponseWriter, request *http.
 	}
 }
 
+func (s *serverImpl) HandleBacklinksLookup(writer http.ResponseWriter, request *http.Request) {
+	values := request.URL.Query()
+	title := values.Get("title")
+
+	backlinks, err := s.logic.LookupBacklinks(context.Background(), title)
+	if err != nil {
+		s.renderError(writer, err)
+	} else {
+		s.renderJSON(writer, map[string]interface{}{
+			"title":     title,
+			"count":     len(backlinks),
+			"backlinks": backlinks,
+		})
+	}
+}
+
+func (s *serverImpl) HandleHistogramLookup(writer http.ResponseWriter, request *http.Request) {
+	values := request.URL.Query()
+	title := values.Get("title")
+
+	ctx, cancel := context.WithTimeout(context.Background(), histogramTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	histogram, err := s.logic.LookupHistogram(ctx, title)
+	duration := time.Since(startTime)
+
+	if err != nil {
+		s.renderError(writer, err)
+	} else {
+		s.renderJSON(writer, map[string]interface{}{
+			"time":        duration.String(),
+			"source":      histogram.Source,
+			"layers":      histogram.Layers,
+			"reached":     histogram.Reached,
+			"unreachable": histogram.Unreachable,
+			"farthest":    histogram.Farthest,
+			"complete":    histogram.Complete,
+		})
+	}
+}
+
+// Looks up how far each target is from the start, with one of the shortest
+// paths to each. Targets are a '|' separated list and may also be repeated.
+// Each distance echoes the target as it was given, with the title that it
+// resolved to alongside it, or the error if it couldn't be looked up.
+// If the lookup times out, the distances found so far are still returned,
+// marked as incomplete.
+func (s *serverImpl) HandleDistancesLookup(writer http.ResponseWriter, request *http.Request) {
+	values := request.URL.Query()
+	start := values.Get("start")
+
+	var targets []string
+	for _, value := range values["target"] {
+		for _, target := range strings.Split(value, "|") {
+			if target != "" {
+				targets = append(targets, target)
+			}
+		}
+	}
+	if len(targets) > maxDistanceTargets {
+		s.renderError(writer, fmt.Errorf("at most %d targets may be looked up at once", maxDistanceTargets))
+		return
+	}
+
+	constraints, err := parseConstraints(values)
+	if err != nil {
+		s.renderError(writer, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), histogramTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	distances, err := s.logic.LookupDistances(ctx, start, targets, constraints)
+	duration := time.Since(startTime)
+
+	if distances == nil {
+		s.renderLookupError(writer, err, histogramTimeout)
+		return
+	}
+
+	rendered := make([]map[string]interface{}, len(distances))
+	for i, distance := range distances {
+		rendered[i] = map[string]interface{}{
+			"target":   targets[i],
+			"distance": distance.Distance,
+		}
+		if distance.Err != nil {
+			rendered[i]["error"] = distance.Err.Error()
+			rendered[i]["code"] = errorCode(distance.Err)
+		} else {
+			rendered[i]["title"] = distance.Target
+			rendered[i]["path"] = distance.Path
+		}
+	}
+
+	s.renderJSON(writer, map[string]interface{}{
+		"time":      duration.String(),
+		"start":     start,
+		"distances": rendered,
+		"complete":  err == nil,
+	})
+}
+
+func (s *serverImpl) HandlePageCacheLookup(writer http.ResponseWriter, request *http.Request) {
+	stats, err := s.logic.LookupPageCacheStats(context.Background())
+	if err != nil {
+		s.renderError(writer, err)
+	} else {
+		s.renderJSON(writer, map[string]interface{}{
+			"hits":   stats.Hits,
+			"misses": stats.Misses,
+			"pages":  stats.Pages,
+			"bytes":  stats.Bytes,
+			"budget": stats.Budget,
+		})
+	}
+}
+
+// Reads the search constraints from the avoid, skip and maxdegree parameters.
+// avoid and skip are '|' separated lists and may also be repeated.
+func parseConstraints(values url.Values) (wiki.Constraints, error) {
+	maxDegree := 0
+	if values.Get("maxdegree") != "" {
+		var err error
+		maxDegree, err = strconv.Atoi(values.Get("maxdegree"))
+		if err != nil || maxDegree < 0 {
+			return wiki.Constraints{}, errors.New("maxdegree must be a number that isn't negative")
+		}
+	}
+
+	maxDepth := 0
+	if values.Get("maxdepth") != "" {
+		var err error
+		maxDepth, err = strconv.Atoi(values.Get("maxdepth"))
+		if err != nil || maxDepth < 1 {
+			return wiki.Constraints{}, errors.New("maxdepth must be a positive number")
+		}
+	}
+
+	maxLinks := 0
+	if values.Get("maxlinks") != "" {
+		var err error
+		maxLinks, err = strconv.Atoi(values.Get("maxlinks"))
+		if err != nil || maxLinks < 0 {
+			return wiki.Constraints{}, errors.New("maxlinks must be a number")
+		}
+	}
+
+	avoid := strings.Join(values["avoid"], "|")
+	skip := strings.Join(values["skip"], "|")
+	constraints := wiki.NewConstraints(avoid, skip, maxDegree)
+	constraints.MaxDepth = maxDepth
+	constraints.LeadOnly = values.Get("leadonly") != ""
+	constraints.MaxLinks = maxLinks
+	return constraints, nil
+}
+
 func (s *serverImpl) renderJSON(writer http.ResponseWriter, resp interface{}) {
 	respBytes, _ := json.Marshal(&resp)
 	io.WriteString(writer, string(respBytes))
 }
 
+// Renders the error along with a short code for the kind of error it is, so
+// that clients don't have to match on the message
 func (s *serverImpl) renderError(writer http.ResponseWriter, err error) {
-	s.renderJSON(writer, map[string]string{"error": err.Error()})
+	s.renderJSON(writer, map[string]string{"error": err.Error(), "code": errorCode(err)})
+}
+
+// Renders the error from a lookup that was given the timeout
+func (s *serverImpl) renderLookupError(writer http.ResponseWriter, err error, timeout time.Duration) {
+	s.renderJSON(writer, map[string]string{"error": lookupErrorMessage(err, timeout), "code": errorCode(err)})
+}
+
+func lookupErrorMessage(err error, timeout time.Duration) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Sprintf("Timed out after %v.", timeout)
+	}
+	return err.Error()
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, wiki.ErrSearchCancelled):
+		return "cancelled"
+	case errors.Is(err, wiki.ErrPageNotFound):
+		return "page_not_found"
+	case errors.Is(err, wiki.ErrNoLinks):
+		return "no_links"
+	case errors.Is(err, wiki.ErrNoPath):
+		return "no_path"
+	case errors.Is(err, wiki.ErrLoaderClosed):
+		return "loader_closed"
+	default:
+		return "error"
+	}
 }
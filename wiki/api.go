@@ -16,17 +16,28 @@ import (
 	"io"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 )
 
 // Represents a wiki page
 // Contains the page's unique title and the titles of all of the pages that it
 // links to.
+//
+// How Links are ordered depends on where the page came from. Pages parsed from
+// wikitext and pages from legacy indexes list the links in the order that they
+// appear on the page, repeats included. Compact indexes store each link once,
+// in the order of the ids that the index gave them, so when several shortest
+// paths tie, which one a search returns can differ between index formats.
+// Use Contexts for where a link is on the page.
 type Page struct {
-	Redirector string   // the original link used to get to the page, usually but not always the same as title
-	Title      string   // the actual title of the page
-	Redirect   string   // the page that this page redirects to
-	Links      []string // the links on the page
+	Redirector string        // the original link used to get to the page, usually but not always the same as title
+	Title      string        // the actual title of the page
+	Redirect   string        // the page that this page redirects to
+	Links      []string      // the links on the page, see above for their order
+	Weights    []int         // the weight of each link, in the same order as Links, or nil if unknown
+	Contexts   []LinkContext // where each link is on the page, in the same order as Links, or nil if unknown
+	Categories []string      // the categories that the page is in, like "Category:Physicists"
 }
 
 // Represents something that can load wiki pages
@@ -36,12 +47,87 @@ type PageLoader interface {
 	io.Closer
 }
 
+// Represents something that knows how many pages there are in total
+// Redirects don't count as pages.
+type PageCounter interface {
+	CountPages() (int, error)
+}
+
+// Represents something that can look up the pages linking to a wiki page
+// Takes the title of the page and returns the titles of the pages that link
+// to it, including those that link to it through a redirect.
+type BacklinkLoader interface {
+	LoadBacklinks(title string) ([]string, error)
+}
+
+// Represents something that can look up the redirects to a wiki page
+// Takes the title of the page and returns the titles that redirect to it.
+type RedirectLoader interface {
+	LoadRedirects(title string) ([]string, error)
+}
+
+// Represents something that can look up the pages in a category
+// Takes the title of the category, including the "Category:" prefix, and
+// returns the titles of the pages that are in it.
+type CategoryLoader interface {
+	LoadCategoryMembers(category string) ([]string, error)
+}
+
+// How well the cache of a CachingPageLoader is doing
+type PageCacheStats struct {
+	Hits   int64
+	Misses int64
+	Pages  int // the number of pages in the cache
+	Bytes  int // roughly how much memory the pages in the cache take up
+	Budget int // the most bytes that the pages in the cache may take up
+}
+
+// Represents a PageLoader that keeps the pages that it loads in memory
+type CachingPageLoader interface {
+	PageLoader
+	CacheStats() PageCacheStats
+}
+
 type PageSaver interface {
 	SavePage(page Page) error
 	SavePages(pages []Page) error
 	io.Closer
 }
 
+// Represents something that can load the full wikitext of a wiki page
+type TextLoader interface {
+	LoadText(title string) (string, error)
+	io.Closer
+}
+
+// Represents something that can save the full wikitext of wiki pages, keyed
+// by their titles
+type TextSaver interface {
+	SaveTexts(texts map[string]string) error
+	io.Closer
+}
+
+// Represents something that can iterate over all of the pages it has saved
+type PageIterator interface {
+	ForEachPage(fn func(page Page) error) error
+}
+
+// Represents something that can record which pages link to which
+// The backlinks and redirectors are keyed by the title that they point to,
+// and are added to any that have already been saved for that title.
+type BacklinkSaver interface {
+	PageIterator
+	SaveBacklinks(backlinks, redirectors map[string][]string) error
+	io.Closer
+}
+
+// Represents something that can record which pages are in which categories
+// The members are keyed by the title of the category, and are added to any
+// that have already been saved for it.
+type CategorySaver interface {
+	SaveCategoryMembers(members map[string][]string) error
+}
+
 // Represents a series of page titles/links that take you from one page
 // to another.
 type TitlePath []string
@@ -57,6 +143,13 @@ func (titlePath TitlePath) Catted(title string) TitlePath {
 	return newTitlePath
 }
 
+// Reverses the path in place, for searches that build it from the end back
+func (titlePath TitlePath) Reverse() {
+	for i, j := 0, len(titlePath)-1; i < j; i, j = i+1, j-1 {
+		titlePath[i], titlePath[j] = titlePath[j], titlePath[i]
+	}
+}
+
 // Represents something that, given a PageLoader, can look up a path from one
 // page to another
 type PathFinder interface {
@@ -64,26 +157,226 @@ type PathFinder interface {
 	FindPath(ctx context.Context, start, end string) (TitlePath, error)
 }
 
+// Represents every shortest path from one page to another as a directed
+// acyclic graph. Each title on a shortest path maps to the titles that come
+// right before it on at least one of the paths.
+type PathDAG struct {
+	Start   string
+	End     string
+	Parents map[string][]string
+}
+
+// Returns the number of distinct shortest paths in the DAG
+func (dag PathDAG) Count() int {
+	if len(dag.Parents) == 0 && dag.Start != dag.End {
+		return 0
+	}
+
+	counts := make(map[string]int)
+	var count func(title string) int
+	count = func(title string) int {
+		if title == dag.Start {
+			return 1
+		}
+		if c, ok := counts[title]; ok {
+			return c
+		}
+
+		c := 0
+		for _, parent := range dag.Parents[title] {
+			c += count(parent)
+		}
+		counts[title] = c
+		return c
+	}
+
+	return count(dag.End)
+}
+
+// Returns up to limit of the shortest paths, sorted so that the same DAG
+// always gives the same paths. A limit less than 1 returns all of them.
+func (dag PathDAG) Paths(limit int) []TitlePath {
+	if len(dag.Parents) == 0 && dag.Start != dag.End {
+		return nil
+	}
+
+	var paths []TitlePath
+	var walk func(reversed TitlePath) bool
+	walk = func(reversed TitlePath) bool {
+		title := reversed.Head()
+		if title == dag.Start {
+			path := make(TitlePath, len(reversed))
+			for i, t := range reversed {
+				path[len(reversed)-1-i] = t
+			}
+			paths = append(paths, path)
+			return limit < 1 || len(paths) < limit
+		}
+
+		parents := append([]string(nil), dag.Parents[title]...)
+		sort.Strings(parents)
+		for _, parent := range parents {
+			if !walk(reversed.Catted(parent)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	walk(TitlePath{dag.End})
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Join(paths[i], "\n") < strings.Join(paths[j], "\n")
+	})
+
+	return paths
+}
+
+// Represents something that, given a PageLoader, can look up every shortest
+// path from one page to another
+type AllPathsFinder interface {
+	SetPageLoader(pageLoader PageLoader)
+	FindAllPaths(ctx context.Context, start, end string) (PathDAG, error)
+}
+
+// Represents something that, given a PageLoader, can look up several distinct
+// paths from one page to another, shortest first
+type KPathFinder interface {
+	SetPageLoader(pageLoader PageLoader)
+	FindKPaths(ctx context.Context, start, end string, k int) ([]TitlePath, error)
+}
+
+// Represents something that, given a PageLoader, can find the shortest path
+// from any one of several start pages to any one of several end pages in a
+// single search
+type MultiPathFinder interface {
+	SetPageLoader(pageLoader PageLoader)
+	FindMultiPath(ctx context.Context, starts, ends []string, constraints Constraints) (TitlePath, error)
+}
+
+// Summarizes how far every page is from a single source page.
+type DistanceHistogram struct {
+	Source      string   // the page that distances are measured from
+	Layers      []int    // the number of pages at each distance, starting with the source at 0
+	Reached     int      // the total number of pages reached
+	Unreachable int      // the number of pages that weren't reached, or -1 if unknown
+	Farthest    []string // some of the pages at the greatest distance
+	Complete    bool     // false if the search was cut off before reaching every page
+}
+
+// Represents something that, given a PageLoader, can work out how far every
+// page is from a source page
+type HistogramFinder interface {
+	SetPageLoader(pageLoader PageLoader)
+	FindHistogram(ctx context.Context, source string) (DistanceHistogram, error)
+}
+
+// Represents a PageLoader whose pages come from an index that can say which
+// version of it they come from, so that results found using one version of
+// the index aren't mixed up with results from another
+type IndexVersioner interface {
+	IndexVersion() (string, error)
+}
+
+// Identifies a path in a PathCache. Paths found in other versions of the
+// index, or by algorithms that choose between paths differently, are kept
+// apart from each other.
+type PathKey struct {
+	Index     string // the version of the index, from IndexVersioner
+	Algorithm string
+	Start     string
+	End       string
+}
+
+// Represents something that keeps paths that have already been found so that
+// they don't have to be searched for again
+type PathCache interface {
+	LoadPath(key PathKey) (TitlePath, bool, error)
+	SavePath(key PathKey, path TitlePath) error
+	Close() error
+}
+
+// How far one of the targets of a DistancesFinder is from the source page
+type TargetDistance struct {
+	Target   string
+	Distance int       // the number of links from the source, or -1 if the target wasn't reached
+	Path     TitlePath // one of the shortest paths from the source, or nil if the target wasn't reached
+
+	// why the target couldn't be looked up, such as it not existing, in
+	// which case it isn't searched for
+	Err error
+}
+
+// Represents something that, given a PageLoader, can work out how far each
+// of several target pages is from a source page in a single search
+type DistancesFinder interface {
+	SetPageLoader(pageLoader PageLoader)
+	FindDistances(ctx context.Context, source string, targets []string, constraints Constraints) ([]TargetDistance, error)
+}
+
+const CategoryPrefix = "Category:"
+
+var linkRegex = regexp.MustCompile("\\[\\[(.+?)(\\]\\]|\\||#)")
+
 // Helper function that parses the links from a page's body text.
+// Category tags mark the page as a member of the category rather than linking
+// to it, so they aren't included. See ParseCategories.
 func ParseLinks(content string) []string {
 	if content == "" {
 		return []string{}
 	}
 
-	regex, _ := regexp.Compile("\\[\\[(.+?)(\\]\\]|\\||#)")
-
-	matches := regex.FindAllStringSubmatch(content, -1)
+	matches := linkRegex.FindAllStringSubmatch(content, -1)
 
 	var links []string
 	for _, match := range matches {
 		link := match[1]
 		link = NormalizeTitle(link)
-		links = append(links, link)
+		if !strings.HasPrefix(link, CategoryPrefix) {
+			links = append(links, link)
+		}
 	}
 
 	return links
 }
 
+// Helper function that parses the categories that a page is in from its body
+// text, without any duplicates.
+func ParseCategories(content string) []string {
+	matches := linkRegex.FindAllStringSubmatch(content, -1)
+
+	var categories []string
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		link := NormalizeTitle(match[1])
+		if !strings.HasPrefix(link, CategoryPrefix) {
+			continue
+		}
+
+		category := CategoryTitle(link)
+		if !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+
+	return categories
+}
+
+// Normalizes the title of a category, adding the "Category:" prefix if it's
+// missing. Returns "" if there's no category name.
+func CategoryTitle(category string) string {
+	category = strings.TrimSpace(category)
+	if len(category) >= len(CategoryPrefix) && strings.EqualFold(category[:len(CategoryPrefix)], CategoryPrefix) {
+		category = category[len(CategoryPrefix):]
+	}
+
+	name := NormalizeTitle(strings.Trim(strings.TrimSpace(category), "_"))
+	if name == "" {
+		return ""
+	}
+	return CategoryPrefix + name
+}
+
 // Helper function that formats and encodes a page title for web lookup
 func EncodeTitle(title string) string {
 	// the first character of the string is case insensitive,
@@ -1,9 +1,11 @@
 package wiki
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"strings"
+	"os"
+	"sort"
 	"sync"
 
 	"github.com/boltdb/bolt"
@@ -13,29 +15,101 @@ const DefaultIndexName = "db/index.db"
 
 var redirectKey = []byte("redir")
 var linksKey = []byte("links")
+var backlinksKey = []byte("backlinks")
+var redirectorsKey = []byte("redirectors")
+var categoriesKey = []byte("categories")
+var membersKey = []byte("members")
+var weightsKey = []byte("weights")
+var contextsKey = []byte("contexts")
 
-const linkSeparator = "\n"
+// the number of pages to read per transaction when iterating over the index
+const pageBatchSize = 10000
 
 type boltLoader struct {
 	// connection to db of {title -> links} mappings
 	index *bolt.DB
 
+	// the layout of the pages in the index
+	format indexFormat
+
 	// waitgroup to keep track of whether the connections are in use
 	wg sync.WaitGroup
 
 	// atomic boolean to block new loads from starting when a close is requested
 	closing   bool
 	closeLock sync.Mutex
+
+	// the number of pages in the index, counted the first time it's needed
+	pageCount     int
+	pageCountErr  error
+	pageCountOnce sync.Once
+}
+
+// The operations that differ between the layouts that an index can be stored in
+type indexFormat interface {
+	// Looks up the page stored under the title without following redirects
+	lookupPage(tx *bolt.Tx, title string) (Page, error)
+
+	// Looks up the pages that link to the title or to any of its redirects
+	lookupBacklinks(tx *bolt.Tx, title string) ([]string, error)
+
+	// Looks up the titles that redirect to the title, or to the page that it
+	// redirects to
+	lookupRedirects(tx *bolt.Tx, title string) ([]string, error)
+
+	// Counts the pages in the index that aren't redirects
+	countPages(tx *bolt.Tx) (int, error)
+
+	// Reads up to limit pages in title order, starting after the given title
+	// or from the beginning if after is nil
+	readPages(tx *bolt.Tx, after []byte, limit int) ([]Page, error)
+
+	savePage(tx *bolt.Tx, page Page) error
+
+	// Adds the links to the ones already stored under key for each title.
+	// Titles that have no page in the index are skipped.
+	appendLinks(tx *bolt.Tx, key []byte, linksByTitle map[string][]string) error
+
+	// Looks up the pages in the category
+	lookupMembers(tx *bolt.Tx, category string) ([]string, error)
+
+	// Adds the members to the ones already stored for each category
+	appendMembers(tx *bolt.Tx, membersByCategory map[string][]string) error
 }
 
 func GetBoltPageLoader() (PageLoader, error) {
-	index, err := bolt.Open(DefaultIndexName, 0600, &bolt.Options{ReadOnly: true})
+	return openBoltLoader(DefaultIndexName, true)
+}
+
+// Opens the index and works out which format it's stored in.
+// Empty indexes are set up to use the compact format.
+func openBoltLoader(indexFilename string, readOnly bool) (*boltLoader, error) {
+	var options *bolt.Options
+	if readOnly {
+		options = &bolt.Options{ReadOnly: true}
+	}
+
+	index, err := bolt.Open(indexFilename, 0600, options)
 	if err != nil {
 		return nil, err
 	}
 
-	pageLoader := boltLoader{index: index}
-	return &pageLoader, nil
+	var format indexFormat
+	err = index.View(func(tx *bolt.Tx) error {
+		format, err = detectFormat(tx)
+		return err
+	})
+	if err == nil && !readOnly {
+		if compact, ok := format.(compactFormat); ok {
+			err = index.Update(compact.init)
+		}
+	}
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	return &boltLoader{index: index, format: format}, nil
 }
 
 func (bl *boltLoader) LoadPage(title string) (Page, error) {
@@ -44,20 +118,27 @@ func (bl *boltLoader) LoadPage(title string) (Page, error) {
 	defer bl.wg.Done()
 
 	if bl.isClosing() {
-		return Page{}, errors.New("Connection closed")
+		return Page{}, ErrLoaderClosed
 	}
 
-	page, err := bl.lookupPage(title)
-	if err != nil {
-		return Page{}, err
-	}
+	var page Page
 
-	// check if the title redirects
-	if page.Redirect != "" {
-		page, err = bl.lookupPage(page.Redirect)
+	err := bl.index.View(func(tx *bolt.Tx) error {
+		var err error
+		page, err = bl.format.lookupPage(tx, title)
 		if err != nil {
-			return Page{}, err
+			return err
 		}
+
+		// check if the title redirects
+		if page.Redirect != "" {
+			page, err = bl.format.lookupPage(tx, page.Redirect)
+		}
+		return err
+	})
+
+	if err != nil {
+		return Page{}, err
 	}
 
 	page.Redirector = title
@@ -65,29 +146,113 @@ func (bl *boltLoader) LoadPage(title string) (Page, error) {
 	return page, nil
 }
 
-func (bl *boltLoader) lookupPage(title string) (Page, error) {
-	page := Page{Title: title}
+func (bl *boltLoader) LoadBacklinks(title string) ([]string, error) {
+	// make sure the connections don't close until we're done
+	bl.wg.Add(1)
+	defer bl.wg.Done()
+
+	if bl.isClosing() {
+		return nil, ErrLoaderClosed
+	}
+
+	var backlinks []string
 
 	err := bl.index.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(title))
+		var err error
+		backlinks, err = bl.format.lookupBacklinks(tx, title)
+		return err
+	})
 
-		if bucket == nil {
-			return errors.New("No entry for title '" + title + "'")
-		}
+	if err != nil {
+		return nil, err
+	} else {
+		return backlinks, nil
+	}
+}
+
+func (bl *boltLoader) LoadRedirects(title string) ([]string, error) {
+	// make sure the connections don't close until we're done
+	bl.wg.Add(1)
+	defer bl.wg.Done()
 
-		page.Redirect = string(bucket.Get(redirectKey))
-		page.Links = decodeLinks(bucket.Get(linksKey))
+	if bl.isClosing() {
+		return nil, ErrLoaderClosed
+	}
 
-		return nil
+	var redirects []string
+
+	err := bl.index.View(func(tx *bolt.Tx) error {
+		var err error
+		redirects, err = bl.format.lookupRedirects(tx, title)
+		return err
 	})
 
 	if err != nil {
-		return Page{}, err
+		return nil, err
+	} else {
+		return redirects, nil
+	}
+}
+
+func (bl *boltLoader) LoadCategoryMembers(category string) ([]string, error) {
+	// make sure the connections don't close until we're done
+	bl.wg.Add(1)
+	defer bl.wg.Done()
+
+	if bl.isClosing() {
+		return nil, ErrLoaderClosed
+	}
+
+	var members []string
+
+	err := bl.index.View(func(tx *bolt.Tx) error {
+		var err error
+		members, err = bl.format.lookupMembers(tx, category)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
 	} else {
-		return page, nil
+		return members, nil
 	}
 }
 
+// Counts the titles in the index that aren't redirects.
+// The index doesn't change while it's open, so the count is only done once.
+func (bl *boltLoader) CountPages() (int, error) {
+	bl.pageCountOnce.Do(func() {
+		bl.pageCountErr = bl.index.View(func(tx *bolt.Tx) error {
+			var err error
+			bl.pageCount, err = bl.format.countPages(tx)
+			return err
+		})
+	})
+
+	return bl.pageCount, bl.pageCountErr
+}
+
+// Identifies the index by when its file was last written and by bolt's id for
+// its latest transaction, both of which move forward whenever it's rebuilt or
+// added to.
+func (bl *boltLoader) IndexVersion() (string, error) {
+	info, err := os.Stat(bl.index.Path())
+	if err != nil {
+		return "", err
+	}
+
+	var txID int
+	err = bl.index.View(func(tx *bolt.Tx) error {
+		txID = tx.ID()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), txID), nil
+}
+
 // Blocks new loads from starting, waits for existing loads to complete,
 // and then shuts down the db connections
 func (bl *boltLoader) Close() error {
@@ -117,19 +282,15 @@ func (bl *boltLoader) isClosing() bool {
 	return bl.closing
 }
 
+// Opens the index for writing. New indexes are written in the compact format,
+// while existing ones keep the format that they were created with.
 func GetBoltPageSaver(indexFilename string) (PageSaver, error) {
-	index, err := bolt.Open(indexFilename, 0600, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	pageLoader := boltLoader{index: index}
-	return &pageLoader, nil
+	return openBoltLoader(indexFilename, false)
 }
 
 func (bl *boltLoader) SavePage(page Page) error {
 	err := bl.index.Update(func(tx *bolt.Tx) error {
-		return bl.savePage(tx, page)
+		return bl.format.savePage(tx, page)
 	})
 
 	return err
@@ -138,7 +299,7 @@ func (bl *boltLoader) SavePage(page Page) error {
 func (bl *boltLoader) SavePages(pages []Page) error {
 	err := bl.index.Update(func(tx *bolt.Tx) error {
 		for _, page := range pages {
-			err := bl.savePage(tx, page)
+			err := bl.format.savePage(tx, page)
 			if err != nil {
 				return err
 			}
@@ -150,33 +311,186 @@ func (bl *boltLoader) SavePages(pages []Page) error {
 	return err
 }
 
-func (bl *boltLoader) savePage(tx *bolt.Tx, page Page) error {
-	bucket, err := tx.CreateBucketIfNotExists([]byte(page.Title))
-	if err != nil {
-		return fmt.Errorf("error while creating bucket for title '%s': '%v'", page.Title, err)
-	}
+func GetBoltBacklinkSaver(indexFilename string) (BacklinkSaver, error) {
+	return openBoltLoader(indexFilename, false)
+}
+
+// Calls fn for every page in the index, in title order.
+// Pages are read in batches so that fn is free to write to the index.
+func (bl *boltLoader) ForEachPage(fn func(page Page) error) error {
+	var after []byte
 
-	if page.Redirect != "" {
-		err = bucket.Put(redirectKey, []byte(page.Redirect))
+	for {
+		var pages []Page
+
+		err := bl.index.View(func(tx *bolt.Tx) error {
+			var err error
+			pages, err = bl.format.readPages(tx, after, pageBatchSize)
+			return err
+		})
 		if err != nil {
 			return err
 		}
+
+		if len(pages) == 0 {
+			return nil
+		}
+		after = []byte(pages[len(pages)-1].Title)
+
+		for _, page := range pages {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
 	}
+}
 
-	if len(page.Links) != 0 {
-		err = bucket.Put(linksKey, encodeLinks(page.Links))
+func (bl *boltLoader) SaveBacklinks(backlinks, redirectors map[string][]string) error {
+	err := bl.index.Update(func(tx *bolt.Tx) error {
+		err := bl.format.appendLinks(tx, backlinksKey, backlinks)
 		if err != nil {
 			return err
 		}
+
+		return bl.format.appendLinks(tx, redirectorsKey, redirectors)
+	})
+
+	return err
+}
+
+func (bl *boltLoader) SaveCategoryMembers(members map[string][]string) error {
+	err := bl.index.Update(func(tx *bolt.Tx) error {
+		return bl.format.appendMembers(tx, members)
+	})
+
+	return err
+}
+
+// Weights are stored as a single byte per link
+func encodeWeights(weights []int) []byte {
+	encoded := make([]byte, len(weights))
+	for i, weight := range weights {
+		if weight < 1 {
+			weight = 1
+		} else if weight > MaxLinkWeight {
+			weight = MaxLinkWeight
+		}
+		encoded[i] = byte(weight)
 	}
+	return encoded
+}
 
-	return nil
+func decodeWeights(encoded []byte) []int {
+	if len(encoded) == 0 {
+		return nil
+	}
+
+	weights := make([]int, len(encoded))
+	for i, weight := range encoded {
+		weights[i] = int(weight)
+	}
+	return weights
+}
+
+// Returns the weights if there's one for each of the links, or nil if not
+func alignedWeights(weights []int, numLinks int) []int {
+	if len(weights) != numLinks {
+		return nil
+	}
+	return weights
+}
+
+// Contexts are stored as a uvarint count of the distinct section headings and
+// the headings themselves, each a uvarint length followed by the text, then a
+// pair of uvarints for each link: the index of its heading, where 0 is the
+// lead section and 1 is the first heading, and its ordinal.
+func encodeContexts(contexts []LinkContext) []byte {
+	var headings []string
+	indexes := map[string]int{"": 0}
+	for _, context := range contexts {
+		if _, ok := indexes[context.Section]; !ok {
+			headings = append(headings, context.Section)
+			indexes[context.Section] = len(headings)
+		}
+	}
+
+	var encoded []byte
+	encoded = appendUvarint(encoded, uint64(len(headings)))
+	for _, heading := range headings {
+		encoded = appendUvarint(encoded, uint64(len(heading)))
+		encoded = append(encoded, heading...)
+	}
+
+	for _, context := range contexts {
+		encoded = appendUvarint(encoded, uint64(indexes[context.Section]))
+		encoded = appendUvarint(encoded, uint64(context.Ordinal))
+	}
+
+	return encoded
+}
+
+func decodeContexts(encoded []byte) ([]LinkContext, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	corrupt := errors.New("corrupt link contexts")
+
+	readUvarint := func() (uint64, bool) {
+		value, n := binary.Uvarint(encoded)
+		if n <= 0 {
+			return 0, false
+		}
+		encoded = encoded[n:]
+		return value, true
+	}
+
+	count, ok := readUvarint()
+	if !ok || count > uint64(len(encoded)) {
+		return nil, corrupt
+	}
+
+	headings := []string{""}
+	for i := uint64(0); i < count; i++ {
+		length, ok := readUvarint()
+		if !ok || length > uint64(len(encoded)) {
+			return nil, corrupt
+		}
+		headings = append(headings, string(encoded[:length]))
+		encoded = encoded[length:]
+	}
+
+	var contexts []LinkContext
+	for len(encoded) > 0 {
+		heading, ok := readUvarint()
+		if !ok || heading >= uint64(len(headings)) {
+			return nil, corrupt
+		}
+		ordinal, ok := readUvarint()
+		if !ok {
+			return nil, corrupt
+		}
+		contexts = append(contexts, LinkContext{Section: headings[heading], Ordinal: int(ordinal)})
+	}
+
+	return contexts, nil
 }
 
-func encodeLinks(links []string) []byte {
-	return []byte(strings.Join(links, linkSeparator))
+// Decodes the contexts, returning nil if there isn't one for each of the links
+func alignedContexts(encoded []byte, numLinks int) ([]LinkContext, error) {
+	contexts, err := decodeContexts(encoded)
+	if err != nil || len(contexts) != numLinks {
+		return nil, err
+	}
+	return contexts, nil
 }
 
-func decodeLinks(encodedLinks []byte) []string {
-	return strings.Split(string(encodedLinks), linkSeparator)
+// Returns the titles that have entries in the map, sorted. bolt is much happier
+// with writes in key order, so titles should be written in this order.
+func sortedTitles[V any](entries map[string]V) []string {
+	titles := make([]string, 0, len(entries))
+	for title := range entries {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles
 }
@@ -0,0 +1,142 @@
+package wiki
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestContextsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		contexts []LinkContext
+	}{
+		{"empty", nil},
+		{"lead only", []LinkContext{{"", 0}, {"", 1}, {"", 2}}},
+		{"several headings", []LinkContext{{"", 0}, {"History", 0}, {"History", 1}, {"See also", 0}}},
+		{"repeated headings", []LinkContext{{"Works", 0}, {"Life", 0}, {"Works", 1}, {"", 3}, {"Life", 1}}},
+		{"large ordinal", []LinkContext{{"Überblick", 100000}}},
+	}
+
+	for _, test := range tests {
+		decoded, err := decodeContexts(encodeContexts(test.contexts))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if !reflect.DeepEqual(decoded, test.contexts) {
+			t.Errorf("%s: got %v, want %v", test.name, decoded, test.contexts)
+		}
+	}
+}
+
+func TestDecodeContextsCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded []byte
+	}{
+		{"unterminated count", []byte{0x80}},
+		{"count longer than the contexts", []byte{0x7f, 1}},
+		{"heading longer than the contexts", []byte{1, 9, 'a'}},
+		{"heading index out of range", []byte{1, 1, 'a', 2, 0}},
+		{"missing ordinal", []byte{0, 0}},
+		{"unterminated ordinal", []byte{0, 0, 0x80}},
+	}
+
+	for _, test := range tests {
+		if contexts, err := decodeContexts(test.encoded); err == nil {
+			t.Errorf("%s: got %v, want an error", test.name, contexts)
+		}
+	}
+}
+
+func TestWeightsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    []int
+	}{
+		{"empty", nil, nil},
+		{"in range", []int{1, 2, MaxLinkWeight}, []int{1, 2, MaxLinkWeight}},
+		{"clamped", []int{0, -4, MaxLinkWeight + 1}, []int{1, 1, MaxLinkWeight}},
+	}
+
+	for _, test := range tests {
+		decoded := decodeWeights(encodeWeights(test.weights))
+		if !reflect.DeepEqual(decoded, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, decoded, test.want)
+		}
+	}
+}
+
+// Saves a page whose links repeat and reads it back, checking that each link
+// keeps its own weight and context in both formats
+func TestSavedPageAlignment(t *testing.T) {
+	page := Page{
+		Title:    "Apple",
+		Links:    []string{"Cherry", "Banana", "Cherry", "Date", "Banana"},
+		Weights:  []int{3, 2, 1, 4, 5},
+		Contexts: []LinkContext{{"", 0}, {"", 1}, {"Uses", 0}, {"Uses", 1}, {"See also", 0}},
+	}
+
+	// compact indexes keep each link once, with its lowest weight and the
+	// context of its first appearance
+	compactWeights := map[string]int{"Banana": 2, "Cherry": 1, "Date": 4}
+	compactContexts := map[string]LinkContext{"Banana": {"", 1}, "Cherry": {"", 0}, "Date": {"Uses", 1}}
+
+	tests := []struct {
+		name   string
+		format indexFormat
+	}{
+		{"legacy", legacyFormat{}},
+		{"compact", compactFormat{}},
+	}
+
+	for _, test := range tests {
+		db, err := bolt.Open(filepath.Join(t.TempDir(), test.name+".db"), 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			if compact, ok := test.format.(compactFormat); ok {
+				if err := compact.init(tx); err != nil {
+					return err
+				}
+			}
+			return test.format.savePage(tx, page)
+		})
+		if err != nil {
+			t.Fatalf("%s: error saving page: %v", test.name, err)
+		}
+
+		var saved Page
+		err = db.View(func(tx *bolt.Tx) error {
+			saved, err = test.format.lookupPage(tx, page.Title)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%s: error reading page: %v", test.name, err)
+		}
+
+		if _, ok := test.format.(legacyFormat); ok {
+			if !reflect.DeepEqual(saved.Links, page.Links) || !reflect.DeepEqual(saved.Weights, page.Weights) || !reflect.DeepEqual(saved.Contexts, page.Contexts) {
+				t.Errorf("%s: got %v %v %v, want %v %v %v", test.name, saved.Links, saved.Weights, saved.Contexts, page.Links, page.Weights, page.Contexts)
+			}
+			continue
+		}
+
+		if len(saved.Links) != len(compactWeights) || len(saved.Weights) != len(saved.Links) || len(saved.Contexts) != len(saved.Links) {
+			t.Fatalf("%s: got %v %v %v, want one weight and context for each of %d links", test.name, saved.Links, saved.Weights, saved.Contexts, len(compactWeights))
+		}
+		for i, link := range saved.Links {
+			if saved.Weights[i] != compactWeights[link] {
+				t.Errorf("%s: got weight %d for %s, want %d", test.name, saved.Weights[i], link, compactWeights[link])
+			}
+			if saved.Contexts[i] != compactContexts[link] {
+				t.Errorf("%s: got context %v for %s, want %v", test.name, saved.Contexts[i], link, compactContexts[link])
+			}
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package wiki
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// The compact format gives every title a numeric id and keeps each kind of
+// data in its own top level bucket keyed by id:
+//
+//	meta        - "format" -> compactFormatVersion
+//	ids         - title -> id
+//	titles      - id -> title
+//	links       - id -> id list, present for every page in the index
+//	weights     - id -> one byte for the weight of each link, in id list order
+//	contexts    - id -> the section and position of each link, in id list order
+//	redir       - id -> id of the page that the title redirects to
+//	backlinks   - id -> id list
+//	redirectors - id -> id list
+//	categories  - id -> id list of the categories that the page is in
+//	members     - category id -> id list of the pages in the category
+//
+// Ids are stored as 4 byte big endian keys so that cursors walk them in order.
+// Id lists are a uvarint count followed by the sorted ids as uvarint deltas,
+// which is a fraction of the size of the titles themselves.
+//
+// Titles are normalized to start with an upper case letter, so the lower case
+// bucket names can't collide with the per-title buckets of the legacy format.
+var metaBucket = []byte("meta")
+var idsBucket = []byte("ids")
+var titlesBucket = []byte("titles")
+
+var formatKey = []byte("format")
+
+const compactFormatVersion = "2"
+
+// Implements indexFormat
+type compactFormat struct{}
+
+// Works out which format the index was written in
+func detectFormat(tx *bolt.Tx) (indexFormat, error) {
+	if meta := tx.Bucket(metaBucket); meta != nil {
+		if version := string(meta.Get(formatKey)); version != compactFormatVersion {
+			return nil, fmt.Errorf("unknown index format version '%s'", version)
+		}
+		return compactFormat{}, nil
+	}
+
+	// anything else that has data in it predates the compact format
+	if title, _ := tx.Cursor().First(); title != nil {
+		return legacyFormat{}, nil
+	}
+
+	return compactFormat{}, nil
+}
+
+// Creates the buckets for an empty index
+func (cf compactFormat) init(tx *bolt.Tx) error {
+	for _, name := range [][]byte{idsBucket, titlesBucket, linksKey, weightsKey, contextsKey, redirectKey, backlinksKey, redirectorsKey, categoriesKey, membersKey} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(formatKey, []byte(compactFormatVersion))
+}
+
+func (cf compactFormat) lookupPage(tx *bolt.Tx, title string) (Page, error) {
+	id, ok := cf.lookupID(tx, title)
+	if !ok {
+		return Page{}, &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	return cf.readPage(tx, title, id)
+}
+
+// Reads the page with the given id, which has to be one that was saved and not
+// just linked to.
+func (cf compactFormat) readPage(tx *bolt.Tx, title string, id []byte) (Page, error) {
+	encodedLinks := tx.Bucket(linksKey).Get(id)
+	if encodedLinks == nil {
+		return Page{}, &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	page := Page{Title: title}
+	if redirect := tx.Bucket(redirectKey).Get(id); redirect != nil {
+		page.Redirect = cf.lookupTitle(tx, redirect)
+	}
+
+	var err error
+	page.Links, err = cf.decodeTitles(tx, encodedLinks)
+	if err != nil {
+		return Page{}, err
+	}
+
+	// indexes from before weights, contexts, or categories were added won't
+	// have buckets for them
+	if weights := tx.Bucket(weightsKey); weights != nil {
+		page.Weights = alignedWeights(decodeWeights(weights.Get(id)), len(page.Links))
+	}
+
+	if contexts := tx.Bucket(contextsKey); contexts != nil {
+		page.Contexts, err = alignedContexts(contexts.Get(id), len(page.Links))
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	if categories := tx.Bucket(categoriesKey); categories != nil {
+		page.Categories, err = cf.decodeTitles(tx, categories.Get(id))
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	return page, nil
+}
+
+func (cf compactFormat) lookupBacklinks(tx *bolt.Tx, title string) ([]string, error) {
+	id, ok := cf.lookupID(tx, title)
+	if !ok || tx.Bucket(linksKey).Get(id) == nil {
+		return nil, &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	// look up the backlinks of the page that the title redirects to
+	if redirect := tx.Bucket(redirectKey).Get(id); redirect != nil {
+		id = redirect
+	}
+
+	backlinksBucket := tx.Bucket(backlinksKey)
+	backlinkIDs, err := decodeIDs(backlinksBucket.Get(id))
+	if err != nil {
+		return nil, err
+	}
+
+	// pages that link to a redirect also link to the page itself
+	redirectorIDs, err := decodeIDs(tx.Bucket(redirectorsKey).Get(id))
+	if err != nil {
+		return nil, err
+	}
+	for _, redirectorID := range redirectorIDs {
+		ids, err := decodeIDs(backlinksBucket.Get(encodeID(redirectorID)))
+		if err != nil {
+			return nil, err
+		}
+		backlinkIDs = append(backlinkIDs, ids...)
+	}
+
+	backlinks := make([]string, 0, len(backlinkIDs))
+	for _, backlinkID := range backlinkIDs {
+		backlinks = append(backlinks, cf.lookupTitle(tx, encodeID(backlinkID)))
+	}
+
+	return backlinks, nil
+}
+
+func (cf compactFormat) lookupRedirects(tx *bolt.Tx, title string) ([]string, error) {
+	id, ok := cf.lookupID(tx, title)
+	if !ok || tx.Bucket(linksKey).Get(id) == nil {
+		return nil, &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	if redirect := tx.Bucket(redirectKey).Get(id); redirect != nil {
+		id = redirect
+	}
+
+	return cf.decodeTitles(tx, tx.Bucket(redirectorsKey).Get(id))
+}
+
+func (cf compactFormat) countPages(tx *bolt.Tx) (int, error) {
+	count := 0
+	redirects := tx.Bucket(redirectKey)
+
+	err := tx.Bucket(linksKey).ForEach(func(id, links []byte) error {
+		if redirects.Get(id) == nil {
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (cf compactFormat) readPages(tx *bolt.Tx, after []byte, limit int) ([]Page, error) {
+	var pages []Page
+	cursor := tx.Bucket(idsBucket).Cursor()
+
+	var title, id []byte
+	if after == nil {
+		title, id = cursor.First()
+	} else if title, id = cursor.Seek(after); bytes.Equal(title, after) {
+		title, id = cursor.Next()
+	}
+
+	linksBucket := tx.Bucket(linksKey)
+	for ; title != nil && len(pages) < limit; title, id = cursor.Next() {
+		// skip titles that are only ever linked to
+		if linksBucket.Get(id) == nil {
+			continue
+		}
+
+		page, err := cf.readPage(tx, string(title), id)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	return pages, nil
+}
+
+func (cf compactFormat) savePage(tx *bolt.Tx, page Page) error {
+	id, err := cf.assignID(tx, page.Title)
+	if err != nil {
+		return fmt.Errorf("error while assigning id for title '%s': '%v'", page.Title, err)
+	}
+
+	if page.Redirect != "" {
+		redirect, err := cf.assignID(tx, page.Redirect)
+		if err != nil {
+			return fmt.Errorf("error while assigning id for title '%s': '%v'", page.Redirect, err)
+		}
+
+		err = tx.Bucket(redirectKey).Put(encodeID(id), encodeID(redirect))
+		if err != nil {
+			return err
+		}
+	}
+
+	linkIDs, err := cf.assignIDs(tx, page.Links)
+	if err != nil {
+		return err
+	}
+
+	// work out the weights and contexts before encoding the ids sorts them
+	var weights []int
+	if len(page.Weights) != 0 && len(page.Weights) == len(linkIDs) {
+		weights = sortWeights(linkIDs, page.Weights)
+	}
+	var contexts []LinkContext
+	if len(page.Contexts) != 0 && len(page.Contexts) == len(linkIDs) {
+		contexts = sortContexts(linkIDs, page.Contexts)
+	}
+
+	err = tx.Bucket(linksKey).Put(encodeID(id), encodeIDs(linkIDs))
+	if err != nil {
+		return err
+	}
+
+	if weights != nil {
+		err = tx.Bucket(weightsKey).Put(encodeID(id), encodeWeights(weights))
+		if err != nil {
+			return err
+		}
+	}
+
+	if contexts != nil {
+		err = tx.Bucket(contextsKey).Put(encodeID(id), encodeContexts(contexts))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(page.Categories) != 0 {
+		categoryIDs, err := cf.assignIDs(tx, page.Categories)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(categoriesKey).Put(encodeID(id), encodeIDs(categoryIDs))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cf compactFormat) lookupMembers(tx *bolt.Tx, category string) ([]string, error) {
+	id, ok := cf.lookupID(tx, category)
+	members := tx.Bucket(membersKey)
+	if !ok || members == nil {
+		return nil, &PageError{Title: category, Err: ErrPageNotFound}
+	}
+
+	return cf.decodeTitles(tx, members.Get(id))
+}
+
+// Unlike backlinks, members are kept for categories that don't have a page of
+// their own, since plenty of categories are only ever used as tags.
+func (cf compactFormat) appendMembers(tx *bolt.Tx, membersByCategory map[string][]string) error {
+	return cf.appendIDLists(tx, membersKey, membersByCategory, false)
+}
+
+func (cf compactFormat) appendLinks(tx *bolt.Tx, key []byte, linksByTitle map[string][]string) error {
+	return cf.appendIDLists(tx, key, linksByTitle, true)
+}
+
+// Adds the titles to the id lists stored in the bucket for each title.
+// Titles that don't have an id yet are skipped, as are titles that don't have
+// a page if onlyPages is set.
+func (cf compactFormat) appendIDLists(tx *bolt.Tx, key []byte, linksByTitle map[string][]string, onlyPages bool) error {
+	type entry struct {
+		id    uint32
+		links []uint32
+	}
+
+	linksBucket := tx.Bucket(linksKey)
+	var entries []entry
+	for title, links := range linksByTitle {
+		id, ok := cf.lookupID(tx, title)
+		if !ok || (onlyPages && linksBucket.Get(id) == nil) {
+			continue
+		}
+
+		linkIDs, err := cf.assignIDs(tx, links)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{decodeID(id), linkIDs})
+	}
+
+	// ids are the keys, so this writes them in key order like sortedTitles
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+
+	bucket := tx.Bucket(key)
+	for _, entry := range entries {
+		id := encodeID(entry.id)
+
+		existing, err := decodeIDs(bucket.Get(id))
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put(id, encodeIDs(append(existing, entry.links...)))
+		if err != nil {
+			return fmt.Errorf("error while saving %s for title '%s': '%v'", key, cf.lookupTitle(tx, id), err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the encoded id of the title, if it has one
+func (cf compactFormat) lookupID(tx *bolt.Tx, title string) ([]byte, bool) {
+	id := tx.Bucket(idsBucket).Get([]byte(title))
+	return id, id != nil
+}
+
+func (cf compactFormat) lookupTitle(tx *bolt.Tx, id []byte) string {
+	return string(tx.Bucket(titlesBucket).Get(id))
+}
+
+// Returns the id of the title, giving it a new one if it doesn't have one yet
+func (cf compactFormat) assignID(tx *bolt.Tx, title string) (uint32, error) {
+	ids := tx.Bucket(idsBucket)
+	if id := ids.Get([]byte(title)); id != nil {
+		return decodeID(id), nil
+	}
+
+	titles := tx.Bucket(titlesBucket)
+	sequence, err := titles.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if sequence > math.MaxUint32 {
+		return 0, errors.New("ran out of page ids")
+	}
+
+	id := uint32(sequence)
+	err = ids.Put([]byte(title), encodeID(id))
+	if err != nil {
+		return 0, err
+	}
+	err = titles.Put(encodeID(id), []byte(title))
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (cf compactFormat) assignIDs(tx *bolt.Tx, titles []string) ([]uint32, error) {
+	ids := make([]uint32, 0, len(titles))
+	for _, title := range titles {
+		id, err := cf.assignID(tx, title)
+		if err != nil {
+			return nil, fmt.Errorf("error while assigning id for title '%s': '%v'", title, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (cf compactFormat) decodeTitles(tx *bolt.Tx, encodedIDs []byte) ([]string, error) {
+	ids, err := decodeIDs(encodedIDs)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	titles := tx.Bucket(titlesBucket)
+	decoded := make([]string, len(ids))
+	for i, id := range ids {
+		decoded[i] = string(titles.Get(encodeID(id)))
+	}
+
+	return decoded, nil
+}
+
+func encodeID(id uint32) []byte {
+	encoded := make([]byte, 4)
+	binary.BigEndian.PutUint32(encoded, id)
+	return encoded
+}
+
+func decodeID(encoded []byte) uint32 {
+	return binary.BigEndian.Uint32(encoded)
+}
+
+// Sorts and dedupes the ids and then encodes them as a count followed by the
+// gaps between consecutive ids. The result is never empty, even for no ids.
+func encodeIDs(ids []uint32) []byte {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	unique := ids[:0]
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			unique = append(unique, id)
+		}
+	}
+
+	encoded := make([]byte, 0, binary.MaxVarintLen32*(len(unique)+1))
+	encoded = appendUvarint(encoded, uint64(len(unique)))
+
+	var previous uint32
+	for _, id := range unique {
+		encoded = appendUvarint(encoded, uint64(id-previous))
+		previous = id
+	}
+
+	return encoded
+}
+
+// Returns the weight of each distinct id in sorted order, matching the order
+// that encodeIDs stores them in. A link that appears more than once on a page
+// gets the lowest of its weights.
+func sortWeights(ids []uint32, weights []int) []int {
+	lowest := make(map[uint32]int, len(ids))
+	for i, id := range ids {
+		if weight, ok := lowest[id]; !ok || weights[i] < weight {
+			lowest[id] = weights[i]
+		}
+	}
+
+	unique := make([]uint32, 0, len(lowest))
+	for id := range lowest {
+		unique = append(unique, id)
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i] < unique[j] })
+
+	sorted := make([]int, len(unique))
+	for i, id := range unique {
+		sorted[i] = lowest[id]
+	}
+	return sorted
+}
+
+// Returns the context of each distinct id in sorted order, matching the order
+// that encodeIDs stores them in. A link that appears more than once on a page
+// gets the context of its first appearance.
+func sortContexts(ids []uint32, contexts []LinkContext) []LinkContext {
+	first := make(map[uint32]LinkContext, len(ids))
+	for i, id := range ids {
+		if _, ok := first[id]; !ok {
+			first[id] = contexts[i]
+		}
+	}
+
+	unique := make([]uint32, 0, len(first))
+	for id := range first {
+		unique = append(unique, id)
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i] < unique[j] })
+
+	sorted := make([]LinkContext, len(unique))
+	for i, id := range unique {
+		sorted[i] = first[id]
+	}
+	return sorted
+}
+
+func decodeIDs(encoded []byte) ([]uint32, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	count, n := binary.Uvarint(encoded)
+	if n <= 0 || count > uint64(len(encoded)) {
+		return nil, errors.New("corrupt id list")
+	}
+	encoded = encoded[n:]
+
+	ids := make([]uint32, count)
+	var previous uint64
+	for i := range ids {
+		delta, n := binary.Uvarint(encoded)
+		if n <= 0 || previous+delta > math.MaxUint32 {
+			return nil, errors.New("corrupt id list")
+		}
+		encoded = encoded[n:]
+
+		previous += delta
+		ids[i] = uint32(previous)
+	}
+
+	return ids, nil
+}
+
+func appendUvarint(encoded []byte, value uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], value)
+	return append(encoded, buf[:n]...)
+}
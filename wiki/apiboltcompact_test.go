@@ -0,0 +1,57 @@
+package wiki
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeIDsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []uint32
+		want []uint32
+	}{
+		{"empty", nil, []uint32{}},
+		{"single", []uint32{7}, []uint32{7}},
+		{"sorted", []uint32{1, 2, 300, 70000}, []uint32{1, 2, 300, 70000}},
+		{"unsorted", []uint32{300, 1, 70000, 2}, []uint32{1, 2, 300, 70000}},
+		{"duplicates", []uint32{5, 3, 5, 3, 5}, []uint32{3, 5}},
+		{"zero and max", []uint32{4294967295, 0}, []uint32{0, 4294967295}},
+	}
+
+	for _, test := range tests {
+		ids := append([]uint32(nil), test.ids...)
+		decoded, err := decodeIDs(encodeIDs(ids))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if !reflect.DeepEqual(decoded, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, decoded, test.want)
+		}
+	}
+}
+
+func TestDecodeIDsMissing(t *testing.T) {
+	ids, err := decodeIDs(nil)
+	if ids != nil || err != nil {
+		t.Errorf("got %v, %v for a missing list, want nil, nil", ids, err)
+	}
+}
+
+func TestDecodeIDsCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded []byte
+	}{
+		{"unterminated count", []byte{0x80}},
+		{"count longer than the list", []byte{0x7f, 1}},
+		{"missing ids", []byte{3, 1, 1}},
+		{"unterminated id", []byte{1, 0x80}},
+		{"id past the largest id", []byte{2, 0xff, 0xff, 0xff, 0xff, 0x0f, 1}},
+	}
+
+	for _, test := range tests {
+		if ids, err := decodeIDs(test.encoded); err == nil {
+			t.Errorf("%s: got %v, want an error", test.name, ids)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+const linkSeparator = "\n"
+
+// The original format, where every title has its own bucket and links are
+// stored as newline separated titles.
+// Implements indexFormat
+type legacyFormat struct{}
+
+func (lf legacyFormat) lookupPage(tx *bolt.Tx, title string) (Page, error) {
+	bucket := tx.Bucket([]byte(title))
+
+	if bucket == nil {
+		return Page{}, &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	return readBucketPage(title, bucket)
+}
+
+func readBucketPage(title string, bucket *bolt.Bucket) (Page, error) {
+	page := Page{Title: title}
+	page.Redirect = string(bucket.Get(redirectKey))
+	page.Links = decodeLinks(bucket.Get(linksKey))
+	page.Weights = alignedWeights(decodeWeights(bucket.Get(weightsKey)), len(page.Links))
+	page.Categories = decodeLinks(bucket.Get(categoriesKey))
+
+	var err error
+	page.Contexts, err = alignedContexts(bucket.Get(contextsKey), len(page.Links))
+	if err != nil {
+		return Page{}, err
+	}
+
+	return page, nil
+}
+
+func (lf legacyFormat) lookupBacklinks(tx *bolt.Tx, title string) ([]string, error) {
+	bucket := tx.Bucket([]byte(title))
+
+	if bucket == nil {
+		return nil, &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	// look up the backlinks of the page that the title redirects to
+	if redirect := bucket.Get(redirectKey); len(redirect) != 0 {
+		bucket = tx.Bucket(redirect)
+		if bucket == nil {
+			return nil, &PageError{Title: string(redirect), Err: ErrPageNotFound}
+		}
+	}
+
+	backlinks := decodeLinks(bucket.Get(backlinksKey))
+
+	// pages that link to a redirect also link to the page itself
+	for _, redirector := range decodeLinks(bucket.Get(redirectorsKey)) {
+		if redirectorBucket := tx.Bucket([]byte(redirector)); redirectorBucket != nil {
+			backlinks = append(backlinks, decodeLinks(redirectorBucket.Get(backlinksKey))...)
+		}
+	}
+
+	return backlinks, nil
+}
+
+func (lf legacyFormat) lookupRedirects(tx *bolt.Tx, title string) ([]string, error) {
+	bucket := tx.Bucket([]byte(title))
+
+	if bucket == nil {
+		return nil, &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	if redirect := bucket.Get(redirectKey); len(redirect) != 0 {
+		bucket = tx.Bucket(redirect)
+		if bucket == nil {
+			return nil, &PageError{Title: string(redirect), Err: ErrPageNotFound}
+		}
+	}
+
+	return decodeLinks(bucket.Get(redirectorsKey)), nil
+}
+
+func (lf legacyFormat) countPages(tx *bolt.Tx) (int, error) {
+	count := 0
+	err := tx.ForEach(func(title []byte, bucket *bolt.Bucket) error {
+		if len(bucket.Get(redirectKey)) == 0 {
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (lf legacyFormat) readPages(tx *bolt.Tx, after []byte, limit int) ([]Page, error) {
+	var pages []Page
+	cursor := tx.Cursor()
+
+	var title []byte
+	if after == nil {
+		title, _ = cursor.First()
+	} else if title, _ = cursor.Seek(after); bytes.Equal(title, after) {
+		title, _ = cursor.Next()
+	}
+
+	for ; title != nil && len(pages) < limit; title, _ = cursor.Next() {
+		bucket := tx.Bucket(title)
+		if bucket == nil {
+			continue
+		}
+
+		page, err := readBucketPage(string(title), bucket)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	return pages, nil
+}
+
+func (lf legacyFormat) savePage(tx *bolt.Tx, page Page) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(page.Title))
+	if err != nil {
+		return fmt.Errorf("error while creating bucket for title '%s': '%v'", page.Title, err)
+	}
+
+	if page.Redirect != "" {
+		err = bucket.Put(redirectKey, []byte(page.Redirect))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(page.Links) != 0 {
+		err = bucket.Put(linksKey, encodeLinks(page.Links))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(page.Weights) != 0 && len(page.Weights) == len(page.Links) {
+		err = bucket.Put(weightsKey, encodeWeights(page.Weights))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(page.Contexts) != 0 && len(page.Contexts) == len(page.Links) {
+		err = bucket.Put(contextsKey, encodeContexts(page.Contexts))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(page.Categories) != 0 {
+		err = bucket.Put(categoriesKey, encodeLinks(page.Categories))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (lf legacyFormat) lookupMembers(tx *bolt.Tx, category string) ([]string, error) {
+	bucket := tx.Bucket([]byte(category))
+
+	if bucket == nil {
+		return nil, &PageError{Title: category, Err: ErrPageNotFound}
+	}
+
+	return decodeLinks(bucket.Get(membersKey)), nil
+}
+
+// Categories are stored with their members in their own page's bucket, so
+// categories that don't have a page of their own are skipped.
+func (lf legacyFormat) appendMembers(tx *bolt.Tx, membersByCategory map[string][]string) error {
+	return lf.appendLinks(tx, membersKey, membersByCategory)
+}
+
+func (lf legacyFormat) appendLinks(tx *bolt.Tx, key []byte, linksByTitle map[string][]string) error {
+	titles := sortedTitles(linksByTitle)
+
+	for _, title := range titles {
+		bucket := tx.Bucket([]byte(title))
+		if bucket == nil {
+			continue
+		}
+
+		links := mergeLinks(decodeLinks(bucket.Get(key)), linksByTitle[title])
+		err := bucket.Put(key, encodeLinks(links))
+		if err != nil {
+			return fmt.Errorf("error while saving backlinks for title '%s': '%v'", title, err)
+		}
+	}
+
+	return nil
+}
+
+// Adds the new links to the existing ones, skipping any that are already
+// there, so that saving the same links again doesn't repeat them
+func mergeLinks(existing, added []string) []string {
+	seen := make(map[string]bool, len(existing)+len(added))
+	merged := make([]string, 0, len(existing)+len(added))
+	for _, link := range append(existing, added...) {
+		if !seen[link] {
+			seen[link] = true
+			merged = append(merged, link)
+		}
+	}
+	return merged
+}
+
+func encodeLinks(links []string) []byte {
+	return []byte(strings.Join(links, linkSeparator))
+}
+
+func decodeLinks(encodedLinks []byte) []string {
+	if len(encodedLinks) == 0 {
+		return nil
+	}
+	return strings.Split(string(encodedLinks), linkSeparator)
+}
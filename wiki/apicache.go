@@ -0,0 +1,246 @@
+package wiki
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Paths are kept in their own db so that the index itself can stay read only
+// while it's being searched.
+const DefaultPathCacheName = "db/paths.db"
+
+// the most paths that the path cache keeps by default
+const DefaultPathCacheSize = 100000
+
+// bolt locks the db while it's open, so another process that has the cache
+// open is only waited on for this long
+const pathCacheTimeout = time.Second
+
+// encoded PathKey -> when the path was last used, followed by the path
+var pathsBucket = []byte("paths")
+
+// when a path was last used -> its encoded PathKey, so that the least
+// recently used paths come first
+var recencyBucket = []byte("recency")
+
+// the length of the big endian sequence numbers that say when a path was used
+const usedLength = 8
+
+// Implements PathCache with a bolt db that holds up to capacity paths and
+// evicts the least recently used ones
+type boltPathCache struct {
+	db       *bolt.DB
+	capacity int
+
+	// the number of paths in the cache. It only changes through this process,
+	// since no other process can open the db at the same time.
+	count     int
+	countLock sync.Mutex
+
+	// the encoded keys of the paths that have been loaded since the last
+	// write, in the order that they were last loaded.
+	// Loads only need to read the db, and recency only matters when paths
+	// are evicted, so it's brought up to date by the next save.
+	loaded     map[string]uint64
+	loadCount  uint64
+	loadedLock sync.Mutex
+}
+
+func GetBoltPathCache(filename string, capacity int) (PathCache, error) {
+	if capacity < 1 {
+		return nil, errors.New("the path cache must be able to hold at least one path")
+	}
+
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: pathCacheTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		paths, err := tx.CreateBucketIfNotExists(pathsBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(recencyBucket); err != nil {
+			return err
+		}
+
+		count = paths.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltPathCache{db: db, capacity: capacity, count: count, loaded: make(map[string]uint64)}, nil
+}
+
+// Looks up the path and notes that it was just used
+func (bpc *boltPathCache) LoadPath(key PathKey) (TitlePath, bool, error) {
+	encodedKey := encodePathKey(key)
+
+	var path TitlePath
+	err := bpc.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(pathsBucket).Get(encodedKey)
+		if value == nil {
+			return nil
+		}
+		if len(value) < usedLength {
+			return errors.New("corrupt entry in path cache")
+		}
+		path = decodeLinks(value[usedLength:])
+		return nil
+	})
+	if err != nil || path == nil {
+		return nil, false, err
+	}
+
+	bpc.loadedLock.Lock()
+	bpc.loadCount++
+	bpc.loaded[string(encodedKey)] = bpc.loadCount
+	bpc.loadedLock.Unlock()
+
+	return path, true, nil
+}
+
+// Marks the paths loaded since the last write as used, in the order that they
+// were loaded
+func (bpc *boltPathCache) markLoaded(tx *bolt.Tx) error {
+	bpc.loadedLock.Lock()
+	loaded := bpc.loaded
+	bpc.loaded = make(map[string]uint64)
+	bpc.loadedLock.Unlock()
+
+	keys := make([]string, 0, len(loaded))
+	for key := range loaded {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return loaded[keys[i]] < loaded[keys[j]]
+	})
+
+	paths := tx.Bucket(pathsBucket)
+	recency := tx.Bucket(recencyBucket)
+	for _, key := range keys {
+		encodedKey := []byte(key)
+
+		// the path may have been evicted since it was loaded
+		value := paths.Get(encodedKey)
+		if len(value) < usedLength {
+			continue
+		}
+		path := append([]byte{}, value[usedLength:]...)
+
+		if err := recency.Delete(value[:usedLength]); err != nil {
+			return err
+		}
+		used, err := markUsed(recency, encodedKey)
+		if err != nil {
+			return err
+		}
+		if err := paths.Put(encodedKey, append(used, path...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Saves the path, evicting the least recently used paths if the cache is full
+func (bpc *boltPathCache) SavePath(key PathKey, path TitlePath) error {
+	encodedKey := encodePathKey(key)
+
+	bpc.countLock.Lock()
+	defer bpc.countLock.Unlock()
+
+	count := bpc.count
+	err := bpc.db.Update(func(tx *bolt.Tx) error {
+		count = bpc.count
+		if err := bpc.markLoaded(tx); err != nil {
+			return err
+		}
+
+		paths := tx.Bucket(pathsBucket)
+		recency := tx.Bucket(recencyBucket)
+
+		if old := paths.Get(encodedKey); len(old) >= usedLength {
+			if err := recency.Delete(old[:usedLength]); err != nil {
+				return err
+			}
+		} else {
+			count++
+		}
+
+		used, err := markUsed(recency, encodedKey)
+		if err != nil {
+			return err
+		}
+		if err := paths.Put(encodedKey, append(used, encodeLinks(path)...)); err != nil {
+			return err
+		}
+
+		for count > bpc.capacity {
+			oldestUsed, oldestKey := recency.Cursor().First()
+			if oldestUsed == nil {
+				break
+			}
+
+			// bolt's slices aren't safe to use once the buckets change
+			oldestUsed = append([]byte{}, oldestUsed...)
+			oldestKey = append([]byte{}, oldestKey...)
+			if err := paths.Delete(oldestKey); err != nil {
+				return err
+			}
+			if err := recency.Delete(oldestUsed); err != nil {
+				return err
+			}
+			count--
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bpc.count = count
+	return nil
+}
+
+// Marks the paths that were loaded since the last save as used before closing,
+// so that the next process to open the cache knows about them
+func (bpc *boltPathCache) Close() error {
+	bpc.countLock.Lock()
+	err := bpc.db.Update(bpc.markLoaded)
+	bpc.countLock.Unlock()
+	if err != nil {
+		bpc.db.Close()
+		return err
+	}
+
+	return bpc.db.Close()
+}
+
+// Records that the path under the key was just used, and returns when
+func markUsed(recency *bolt.Bucket, encodedKey []byte) ([]byte, error) {
+	seq, err := recency.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	used := make([]byte, usedLength)
+	binary.BigEndian.PutUint64(used, seq)
+	return used, recency.Put(used, encodedKey)
+}
+
+// None of the parts of a key can have a newline in them, so they're stored
+// the same way as a list of links
+func encodePathKey(key PathKey) []byte {
+	return encodeLinks([]string{key.Index, key.Algorithm, key.Start, key.End})
+}
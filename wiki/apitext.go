@@ -0,0 +1,95 @@
+package wiki
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+
+	"github.com/boltdb/bolt"
+)
+
+// The full text of every page is much too big to keep in the index itself,
+// so it's kept in its own db where it's only read to explain paths.
+const DefaultTextIndexName = "db/text.db"
+
+// title -> the page's wikitext, compressed with flate
+var textBucket = []byte("text")
+
+type boltTextStore struct {
+	db *bolt.DB
+}
+
+func GetBoltTextLoader() (TextLoader, error) {
+	db, err := bolt.Open(DefaultTextIndexName, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &boltTextStore{db}, nil
+}
+
+func GetBoltTextSaver(filename string) (TextSaver, error) {
+	db, err := bolt.Open(filename, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(textBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltTextStore{db}, nil
+}
+
+func (bts *boltTextStore) LoadText(title string) (string, error) {
+	var compressed []byte
+	err := bts.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(textBucket); bucket != nil {
+			if value := bucket.Get([]byte(title)); value != nil {
+				compressed = append([]byte{}, value...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if compressed == nil {
+		return "", &PageError{Title: title, Err: ErrPageNotFound}
+	}
+
+	text, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	return string(text), err
+}
+
+func (bts *boltTextStore) SaveTexts(texts map[string]string) error {
+	titles := sortedTitles(texts)
+
+	return bts.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(textBucket)
+		for _, title := range titles {
+			var compressed bytes.Buffer
+			writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+			if err != nil {
+				return err
+			}
+			writer.Write([]byte(texts[title]))
+			if err := writer.Close(); err != nil {
+				return err
+			}
+
+			if err := bucket.Put([]byte(title), compressed.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bts *boltTextStore) Close() error {
+	return bts.db.Close()
+}
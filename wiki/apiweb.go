@@ -2,8 +2,6 @@ package wiki
 
 import (
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 )
@@ -20,30 +18,39 @@ func GetWebPageLoader() PageLoader {
 }
 
 func (wl webLoader) LoadPage(title string) (Page, error) {
-	body, err := wl.loadPageContentFromApi(title)
+	content, err := wl.LoadText(title)
 	if err != nil {
 		return Page{}, err
 	}
 
+	links, weights, contexts := ParseLinkContexts(content)
+	categories := ParseCategories(content)
+
+	// TODO: actually implement redirect support in the web loader
+	redirector := title
+	page := Page{Redirector: redirector, Title: title, Links: links, Weights: weights, Contexts: contexts, Categories: categories}
+	return page, nil
+}
+
+func (wl webLoader) LoadText(title string) (string, error) {
+	body, err := wl.loadPageContentFromApi(title)
+	if err != nil {
+		return "", err
+	}
+
 	var query jsonPageQuery
 	err = json.Unmarshal(body, &query)
 	if err != nil {
-		return Page{}, err
+		return "", err
 	}
 
 	for _, jsonPage := range query.Query.Pages {
 		for _, revision := range jsonPage.Revisions {
-			content := revision["*"]
-			links := ParseLinks(content)
-
-			// TODO: actually implement redirect support in the web loader
-			redirector := title
-			page := Page{Redirector: redirector, Title: title, Links: links}
-			return page, nil
+			return revision["*"], nil
 		}
 	}
 
-	return Page{}, errors.New(fmt.Sprint("No revisions found for", title))
+	return "", &PageError{Title: title, Err: ErrPageNotFound}
 }
 
 func (wl webLoader) Close() error {
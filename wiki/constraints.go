@@ -0,0 +1,108 @@
+package wiki
+
+import (
+	"context"
+	"strings"
+)
+
+// Represents restrictions on which pages a search may pass through.
+// The start and end pages of a search are always allowed.
+type Constraints struct {
+	Avoid        map[string]bool // titles that may not appear in the path
+	SkipPrefixes []string        // title prefixes, like "File:", that may not appear in the path
+	MaxOutDegree int             // pages with more links than this are not followed, 0 for no limit
+	MaxDepth     int             // the most links that the path may have, 0 for no limit
+	LeadOnly     bool            // only links in the lead section of each page are followed
+	MaxLinks     int             // only the first this many links on each page are followed, 0 for no limit
+}
+
+// Helper function that builds Constraints from '|' separated lists of titles
+// to avoid and prefixes to skip, the same way that the wikipedia api takes
+// lists of titles.
+func NewConstraints(avoid, skipPrefixes string, maxOutDegree int) Constraints {
+	constraints := Constraints{MaxOutDegree: maxOutDegree}
+
+	for _, title := range splitList(avoid) {
+		if constraints.Avoid == nil {
+			constraints.Avoid = make(map[string]bool)
+		}
+		constraints.Avoid[NormalizeTitle(title)] = true
+	}
+
+	for _, prefix := range splitList(skipPrefixes) {
+		constraints.SkipPrefixes = append(constraints.SkipPrefixes, strings.Replace(prefix, " ", "_", -1))
+	}
+
+	return constraints
+}
+
+// Whether a path may pass through the title
+func (c Constraints) Allows(title string) bool {
+	if c.Avoid[title] {
+		return false
+	}
+
+	for _, prefix := range c.SkipPrefixes {
+		if strings.HasPrefix(title, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether a path may follow the links of the page
+func (c Constraints) AllowsLinksOf(page Page) bool {
+	return c.MaxOutDegree == 0 || len(page.Links) <= c.MaxOutDegree
+}
+
+// Whether a path may follow the links of a page that's depth links from the
+// start
+func (c Constraints) AllowsLinksAt(depth int) bool {
+	return c.MaxDepth == 0 || depth < c.MaxDepth
+}
+
+// Whether a path may follow the page's link at index i, based on where the
+// link is on the page. Links that weren't recorded with a context, like those
+// from older indexes, are always allowed.
+func (c Constraints) AllowsLink(page Page, i int) bool {
+	if !c.NeedsLinkContexts() {
+		return true
+	}
+
+	context, ok := page.LinkContext(i)
+	if !ok {
+		return true
+	}
+	if c.LeadOnly && context.Section != "" {
+		return false
+	}
+	return c.MaxLinks == 0 || context.Ordinal < c.MaxLinks
+}
+
+// Whether the constraints depend on where links are on their pages
+func (c Constraints) NeedsLinkContexts() bool {
+	return c.LeadOnly || c.MaxLinks != 0
+}
+
+// Whether the constraints don't restrict anything
+func (c Constraints) IsEmpty() bool {
+	return len(c.Avoid) == 0 && len(c.SkipPrefixes) == 0 && c.MaxOutDegree == 0 && c.MaxDepth == 0 && !c.NeedsLinkContexts()
+}
+
+// Represents a PathFinder that can also restrict the pages that the path
+// goes through
+type ConstrainedPathFinder interface {
+	PathFinder
+	FindConstrainedPath(ctx context.Context, start, end string, constraints Constraints) (TitlePath, error)
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,55 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+)
+
+// Errors returned by page loaders and path finders.
+// Callers should check for them with errors.Is, since they're usually wrapped
+// with more details, like in a PageError.
+var (
+	ErrPageNotFound    = errors.New("page not found")
+	ErrNoLinks         = errors.New("page has no links")
+	ErrNoPath          = errors.New("no path found")
+	ErrSearchCancelled = errors.New("search cancelled")
+	ErrLoaderClosed    = errors.New("page loader closed")
+)
+
+// An error about a specific page, like ErrPageNotFound or ErrNoLinks
+type PageError struct {
+	Title string
+	Err   error
+}
+
+func (pe *PageError) Error() string {
+	return pe.Err.Error() + ": '" + pe.Title + "'"
+}
+
+func (pe *PageError) Unwrap() error {
+	return pe.Err
+}
+
+// Returns the error for a search that stopped because its context is done.
+// It matches both ErrSearchCancelled and the context's own error, so callers
+// can still tell a timeout apart from other cancellations with
+// errors.Is(err, context.DeadlineExceeded).
+func CancelledError(ctx context.Context) error {
+	return &cancelledError{ctx.Err()}
+}
+
+type cancelledError struct {
+	cause error
+}
+
+func (ce *cancelledError) Error() string {
+	return ErrSearchCancelled.Error() + ": " + ce.cause.Error()
+}
+
+func (ce *cancelledError) Is(target error) bool {
+	return target == ErrSearchCancelled
+}
+
+func (ce *cancelledError) Unwrap() error {
+	return ce.cause
+}
@@ -0,0 +1,142 @@
+package wiki
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// the most characters of a page's text that are kept for a snippet
+const maxSnippetLength = 300
+
+// Explains a single link in a path, by showing where it is in the text of the
+// page that it's on
+type Hop struct {
+	From    string // the page that the link is on
+	To      string // the page that the link leads to
+	Link    string // the title that the link uses, which may redirect to To, or "" if it wasn't found
+	Anchor  string // the text that the link is shown as
+	Snippet string // the sentence that the link is in, without the wiki markup
+}
+
+// Explains every link in the path using the text of the pages.
+// The page loader is used to find the redirects to each page, if it knows
+// them, since the text may link to a page through one of its redirects.
+// A link that can't be found in the text, like one that comes from a template,
+// gets a Hop with only From and To set, and so does one from a page that has
+// no text.
+func ExplainPath(textLoader TextLoader, pageLoader PageLoader, path TitlePath) ([]Hop, error) {
+	var hops []Hop
+	for i := 0; i+1 < len(path); i++ {
+		from, to := path[i], path[i+1]
+
+		text, err := textLoader.LoadText(from)
+		if errors.Is(err, ErrPageNotFound) {
+			hops = append(hops, Hop{From: from, To: to})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		targets := map[string]bool{to: true}
+		if redirectLoader, ok := pageLoader.(RedirectLoader); ok {
+			redirects, err := redirectLoader.LoadRedirects(to)
+			if err != nil {
+				return nil, err
+			}
+			for _, redirect := range redirects {
+				targets[redirect] = true
+			}
+		}
+
+		hop := ExplainLink(text, targets)
+		hop.From = from
+		hop.To = to
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+// Finds the first link in the text to any of the target titles and returns
+// its anchor text and the sentence that it's in. Only Link, Anchor, and
+// Snippet are set, and Link is "" if there's no such link.
+func ExplainLink(text string, targets map[string]bool) Hop {
+	for _, match := range linkRegex.FindAllStringIndex(text, -1) {
+		end := strings.Index(text[match[0]:], "]]")
+		if end < 0 {
+			break
+		}
+		end += match[0] + len("]]")
+
+		// a link looks like [[Title#Section|anchor text]]
+		inner := text[match[0]+len("[[") : end-len("]]")]
+		target := inner
+		anchor := inner
+		if i := strings.Index(inner, "|"); i >= 0 {
+			target = inner[:i]
+			anchor = inner[strings.LastIndex(inner, "|")+1:]
+		}
+		if i := strings.Index(target, "#"); i >= 0 {
+			target = target[:i]
+		}
+
+		link := NormalizeTitle(strings.TrimSpace(target))
+		if !targets[link] {
+			continue
+		}
+
+		return Hop{Link: link, Anchor: anchor, Snippet: snippetAround(text, match[0], end, anchor)}
+	}
+
+	return Hop{}
+}
+
+// a sentence usually ends with a period and then a space or a footnote
+var sentenceEndRegex = regexp.MustCompile(`\.(\s|<ref)`)
+
+var snippetRefRegex = regexp.MustCompile(`(?s)<ref[^>]*/>|<ref(\s[^>]*)?>.*?</ref>`)
+var snippetTemplateRegex = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+var snippetLinkRegex = regexp.MustCompile(`\[\[(?:[^\]|]*\|)*([^\]|]*)\]\]`)
+var snippetSpaceRegex = regexp.MustCompile(`\s+`)
+
+// Returns the sentence of the text that the link from start to end is in, with
+// the markup stripped out, cut down to around the anchor if it's too long
+func snippetAround(text string, start, end int, anchor string) string {
+	from := strings.LastIndex(text[:start], "\n") + 1
+	if ends := sentenceEndRegex.FindAllStringIndex(text[from:start], -1); ends != nil {
+		from += ends[len(ends)-1][0] + len(".")
+	}
+
+	to := len(text)
+	if i := strings.Index(text[end:], "\n"); i >= 0 {
+		to = end + i
+	}
+	if sentenceEnd := sentenceEndRegex.FindStringIndex(text[end:to]); sentenceEnd != nil {
+		to = end + sentenceEnd[0] + len(".")
+	}
+
+	snippet := text[from:to]
+	snippet = snippetRefRegex.ReplaceAllString(snippet, "")
+	// templates can be nested, so strip them from the inside out
+	for snippetTemplateRegex.MatchString(snippet) {
+		snippet = snippetTemplateRegex.ReplaceAllString(snippet, "")
+	}
+	snippet = snippetLinkRegex.ReplaceAllString(snippet, "$1")
+	snippet = strings.Replace(snippet, "'''", "", -1)
+	snippet = strings.Replace(snippet, "''", "", -1)
+	snippet = strings.TrimSpace(snippetSpaceRegex.ReplaceAllString(snippet, " "))
+
+	if len(snippet) <= maxSnippetLength {
+		return snippet
+	}
+
+	// keep the part around the anchor
+	offset := strings.Index(snippet, anchor) - maxSnippetLength/2
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(snippet)-maxSnippetLength {
+		offset = len(snippet) - maxSnippetLength
+	}
+	return strings.ToValidUTF8(snippet[offset:offset+maxSnippetLength], "")
+}
@@ -0,0 +1,153 @@
+package wiki
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Link weights, for searches that care about more than the number of links.
+// Links in the lead section of an article are usually about its subject, while
+// links in footnotes and in sections like "References" usually aren't.
+const (
+	LeadLinkWeight     = 1
+	BodyLinkWeight     = 2
+	AppendixLinkWeight = 5
+)
+
+// the weight of a link whose weight wasn't recorded, like in older indexes
+const DefaultLinkWeight = BodyLinkWeight
+
+// the most that a weight can be, since they're stored in a single byte
+const MaxLinkWeight = 255
+
+// Returns the weight of the page's link at index i
+func (page Page) LinkWeight(i int) int {
+	if i < len(page.Weights) {
+		return page.Weights[i]
+	}
+	return DefaultLinkWeight
+}
+
+// Where a link is on its page
+type LinkContext struct {
+	Section string // the heading of the section that the link is under, or "" for the lead section
+	Ordinal int    // the position of the link among the links on the page, starting from 0
+}
+
+// Returns where the page's link at index i is on the page, and false if that
+// wasn't recorded
+func (page Page) LinkContext(i int) (LinkContext, bool) {
+	if i < len(page.Contexts) {
+		return page.Contexts[i], true
+	}
+	return LinkContext{}, false
+}
+
+var headingRegex = regexp.MustCompile(`(?m)^(==+)\s*([^=].*?)\s*==+\s*$`)
+var refRegex = regexp.MustCompile(`(?s)<ref(\s[^>]*[^>/])?>.*?</ref>`)
+
+// the top level sections at the end of an article that hold citations and
+// links to other articles rather than content about the subject
+var appendixSections = map[string]bool{
+	"see also":             true,
+	"notes":                true,
+	"footnotes":            true,
+	"citations":            true,
+	"references":           true,
+	"notes and references": true,
+	"sources":              true,
+	"bibliography":         true,
+	"further reading":      true,
+	"external links":       true,
+}
+
+// A section heading on a page
+type section struct {
+	offset int    // where the heading starts in the page's text
+	level  int    // 2 for a top level "== Heading ==", 3 for "=== Heading ===" and so on
+	title  string // the text of the heading
+}
+
+func parseSections(content string) []section {
+	var sections []section
+	for _, match := range headingRegex.FindAllStringSubmatchIndex(content, -1) {
+		sections = append(sections, section{
+			offset: match[0],
+			level:  match[3] - match[2],
+			title:  content[match[4]:match[5]],
+		})
+	}
+	return sections
+}
+
+// Helper function that parses the links from a page's body text along with the
+// weight of each link, based on where it is on the page. Returns the same links
+// as ParseLinks.
+func ParseWeightedLinks(content string) ([]string, []int) {
+	links, weights, _ := ParseLinkContexts(content)
+	return links, weights
+}
+
+// Like ParseWeightedLinks, but also returns the section that each link is in
+// and its position on the page.
+func ParseLinkContexts(content string) ([]string, []int, []LinkContext) {
+	if content == "" {
+		return []string{}, []int{}, []LinkContext{}
+	}
+
+	sections := parseSections(content)
+	refs := refRegex.FindAllStringIndex(content, -1)
+
+	var links []string
+	var weights []int
+	var contexts []LinkContext
+	for _, match := range linkRegex.FindAllStringSubmatchIndex(content, -1) {
+		link := NormalizeTitle(content[match[2]:match[3]])
+		if strings.HasPrefix(link, CategoryPrefix) {
+			continue
+		}
+
+		contexts = append(contexts, LinkContext{Section: sectionAt(match[0], sections), Ordinal: len(links)})
+		links = append(links, link)
+		weights = append(weights, linkWeightAt(match[0], sections, refs))
+	}
+
+	return links, weights, contexts
+}
+
+// Returns the heading of the section that the offset is in, or "" if it's in
+// the lead section
+func sectionAt(offset int, sections []section) string {
+	i := sort.Search(len(sections), func(i int) bool { return sections[i].offset > offset })
+	if i == 0 {
+		return ""
+	}
+	return sections[i-1].title
+}
+
+// Works out the weight of a link from where it is on the page
+func linkWeightAt(offset int, sections []section, refs [][]int) int {
+	// footnotes are appendix links no matter where they are
+	i := sort.Search(len(refs), func(i int) bool { return refs[i][1] > offset })
+	if i < len(refs) && refs[i][0] <= offset {
+		return AppendixLinkWeight
+	}
+
+	// find the top level section that the link is in
+	i = sort.Search(len(sections), func(i int) bool { return sections[i].offset > offset })
+	if i == 0 {
+		return LeadLinkWeight
+	}
+
+	// subsections are part of the top level section that they're in
+	i--
+	for i > 0 && sections[i].level > 2 {
+		i--
+	}
+
+	if appendixSections[strings.ToLower(sections[i].title)] {
+		return AppendixLinkWeight
+	}
+	return BodyLinkWeight
+}
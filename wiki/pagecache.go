@@ -0,0 +1,215 @@
+package wiki
+
+import (
+	"container/list"
+	"sync"
+)
+
+// roughly how much memory the page cache may use by default
+const DefaultPageCacheBudget = 256 * 1024 * 1024
+
+// rough sizes of the parts of a page that aren't the text of its titles,
+// used to guess how much memory a cached page takes up
+const (
+	pageOverhead    = 256 // the Page itself, its list element, and its map entry
+	stringOverhead  = 16
+	weightSize      = 8
+	contextOverhead = stringOverhead + 8
+)
+
+// Wraps a page loader so that the pages that it loads are kept in memory,
+// which saves re-reading and re-decoding hub pages that nearly every search
+// passes through. Once the pages take up more than roughly budget bytes the
+// least recently used ones are dropped.
+// Pages are cached under the title that they were loaded with, and errors
+// aren't cached. The cached pages are shared, so callers must not modify them.
+//
+// The wrapper supports the same optional interfaces that the page loader does,
+// passing them through without caching, so that searches that check for them
+// behave the same as without it. An index like the bolt one supports
+// BacklinkLoader, RedirectLoader, CategoryLoader, PageCounter and
+// IndexVersioner together, and those are only passed through as a set.
+func GetCachingPageLoader(pageLoader PageLoader, budget int) CachingPageLoader {
+	cache := &cachingLoader{
+		pageLoader: pageLoader,
+		budget:     budget,
+		entries:    make(map[string]*list.Element),
+		recency:    list.New(),
+	}
+
+	index, isIndex := pageLoader.(indexLoader)
+	text, isText := pageLoader.(TextLoader)
+	switch {
+	case isIndex && isText:
+		return &cachingIndexTextLoader{cachingIndexLoader{cache, index}, text}
+	case isIndex:
+		return &cachingIndexLoader{cache, index}
+	case isText:
+		return &cachingTextLoader{cache, text}
+	default:
+		return cache
+	}
+}
+
+// The optional interfaces that an index supports
+type indexLoader interface {
+	BacklinkLoader
+	RedirectLoader
+	CategoryLoader
+	PageCounter
+	IndexVersioner
+}
+
+// Implements CachingPageLoader
+type cachingLoader struct {
+	pageLoader PageLoader
+	budget     int
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+
+	// the cached pages, from most to least recently used
+	recency *list.List
+	bytes   int
+
+	hits   int64
+	misses int64
+}
+
+// Implements CachingPageLoader, BacklinkLoader, RedirectLoader,
+// CategoryLoader, PageCounter and IndexVersioner
+type cachingIndexLoader struct {
+	*cachingLoader
+	index indexLoader
+}
+
+// Implements CachingPageLoader and TextLoader
+type cachingTextLoader struct {
+	*cachingLoader
+	text TextLoader
+}
+
+// Implements CachingPageLoader, TextLoader and the interfaces of an index
+type cachingIndexTextLoader struct {
+	cachingIndexLoader
+	text TextLoader
+}
+
+type pageCacheEntry struct {
+	title string
+	page  Page
+	size  int
+}
+
+func (cl *cachingLoader) LoadPage(title string) (Page, error) {
+	if page, ok := cl.lookup(title); ok {
+		return page, nil
+	}
+
+	// the lock isn't held while loading, so two goroutines may both load a
+	// page that neither found, which is cheaper than making them wait
+	page, err := cl.pageLoader.LoadPage(title)
+	if err != nil {
+		return Page{}, err
+	}
+
+	cl.add(title, page)
+	return page, nil
+}
+
+func (cl *cachingLoader) lookup(title string) (Page, bool) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	element, ok := cl.entries[title]
+	if !ok {
+		cl.misses++
+		return Page{}, false
+	}
+
+	cl.hits++
+	cl.recency.MoveToFront(element)
+	return element.Value.(*pageCacheEntry).page, true
+}
+
+func (cl *cachingLoader) add(title string, page Page) {
+	size := approximatePageSize(title, page)
+	if size > cl.budget {
+		return
+	}
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	if element, ok := cl.entries[title]; ok {
+		cl.recency.MoveToFront(element)
+		return
+	}
+
+	entry := &pageCacheEntry{title, page, size}
+	cl.entries[title] = cl.recency.PushFront(entry)
+	cl.bytes += size
+
+	for cl.bytes > cl.budget {
+		oldest := cl.recency.Remove(cl.recency.Back()).(*pageCacheEntry)
+		delete(cl.entries, oldest.title)
+		cl.bytes -= oldest.size
+	}
+}
+
+// Implements CachingPageLoader.CacheStats()
+func (cl *cachingLoader) CacheStats() PageCacheStats {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	return PageCacheStats{Hits: cl.hits, Misses: cl.misses, Pages: len(cl.entries), Bytes: cl.bytes, Budget: cl.budget}
+}
+
+func (cil *cachingIndexLoader) LoadBacklinks(title string) ([]string, error) {
+	return cil.index.LoadBacklinks(title)
+}
+
+func (cil *cachingIndexLoader) LoadRedirects(title string) ([]string, error) {
+	return cil.index.LoadRedirects(title)
+}
+
+func (cil *cachingIndexLoader) LoadCategoryMembers(category string) ([]string, error) {
+	return cil.index.LoadCategoryMembers(category)
+}
+
+func (cil *cachingIndexLoader) CountPages() (int, error) {
+	return cil.index.CountPages()
+}
+
+func (cil *cachingIndexLoader) IndexVersion() (string, error) {
+	return cil.index.IndexVersion()
+}
+
+func (ctl *cachingTextLoader) LoadText(title string) (string, error) {
+	return ctl.text.LoadText(title)
+}
+
+func (citl *cachingIndexTextLoader) LoadText(title string) (string, error) {
+	return citl.text.LoadText(title)
+}
+
+func (cl *cachingLoader) Close() error {
+	return cl.pageLoader.Close()
+}
+
+// Guesses how many bytes the page takes up in the cache, counting the text of
+// its titles and a rough overhead for everything else
+func approximatePageSize(title string, page Page) int {
+	size := pageOverhead + len(title) + len(page.Redirector) + len(page.Title) + len(page.Redirect)
+	for _, link := range page.Links {
+		size += stringOverhead + len(link)
+	}
+	for _, category := range page.Categories {
+		size += stringOverhead + len(category)
+	}
+	for _, linkContext := range page.Contexts {
+		size += contextOverhead + len(linkContext.Section)
+	}
+	size += weightSize * len(page.Weights)
+	return size
+}
@@ -0,0 +1,58 @@
+package wiki
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Calls fn with every index from 0 up to n using a pool of threads goroutines,
+// and returns once all of the calls are done. Calls run in no particular
+// order, so fn should write its result to the slot for its index.
+func ForEachIndex(n, threads int, fn func(index int)) {
+	indexes := make(chan int)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(threads)
+	for i := 0; i < threads; i++ {
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				fn(index)
+			}
+		}()
+	}
+
+	for index := 0; index < n; index++ {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// Loads every title using a pool of threads loader goroutines and reports
+// each load to the search trace in ctx. The pages are returned in the same
+// order as the titles so that callers can process them deterministically.
+// Pages that fail to load, or that aren't started before ctx is done, are
+// left empty.
+func LoadPages(ctx context.Context, pageLoader PageLoader, titles []string, threads int) []Page {
+	pages := make([]Page, len(titles))
+	trace := ContextSearchTrace(ctx)
+
+	ForEachIndex(len(titles), threads, func(index int) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		title := titles[index]
+		page, err := pageLoader.LoadPage(title)
+		if err == nil {
+			pages[index] = page
+		} else {
+			log.Println("Error loading page:", title, "error:", err)
+		}
+		trace.PageLoad(title, err)
+	})
+
+	return pages
+}
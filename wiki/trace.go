@@ -0,0 +1,144 @@
+package wiki
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Hooks for following the progress of a search, in the style of
+// net/http/httptrace. Attach one to a search's context with WithSearchTrace.
+// Any of the hooks may be nil, and they may be called from multiple
+// goroutines at once.
+type SearchTrace struct {
+	// Called after each page is loaded, with the error if the load failed
+	OnPageLoad func(title string, err error)
+
+	// Called after each whole depth layer of the search has been expanded
+	OnLevel func(level LevelStats)
+
+	// Called when the search finishes, with the number of links in the path
+	// that it found or -1 if it didn't find one
+	OnDone func(depth int)
+
+	// Called when the path was found in a PathCache instead of searched for
+	OnCacheHit func()
+}
+
+// What happened while expanding one depth layer of a search
+type LevelStats struct {
+	Depth int
+
+	// the number of pages in the layer that was expanded
+	Frontier int
+
+	Elapsed time.Duration
+}
+
+type searchTraceKey struct{}
+
+// Returns a copy of ctx that searches will report their progress to trace from
+func WithSearchTrace(ctx context.Context, trace *SearchTrace) context.Context {
+	return context.WithValue(ctx, searchTraceKey{}, trace)
+}
+
+// Returns the trace attached to ctx, or nil if there isn't one.
+// The methods on SearchTrace are safe to call on nil.
+func ContextSearchTrace(ctx context.Context) *SearchTrace {
+	trace, _ := ctx.Value(searchTraceKey{}).(*SearchTrace)
+	return trace
+}
+
+func (st *SearchTrace) PageLoad(title string, err error) {
+	if st != nil && st.OnPageLoad != nil {
+		st.OnPageLoad(title, err)
+	}
+}
+
+func (st *SearchTrace) Level(level LevelStats) {
+	if st != nil && st.OnLevel != nil {
+		st.OnLevel(level)
+	}
+}
+
+func (st *SearchTrace) Done(depth int) {
+	if st != nil && st.OnDone != nil {
+		st.OnDone(depth)
+	}
+}
+
+func (st *SearchTrace) CacheHit() {
+	if st != nil && st.OnCacheHit != nil {
+		st.OnCacheHit()
+	}
+}
+
+// A summary of the work that a search did.
+// When several searches share a trace, like the legs of a waypoint search,
+// the counts add up across all of them and Depth is from the last one.
+type SearchStats struct {
+	PagesLoaded int
+	LoadErrors  int
+
+	// only filled in by searches that work one depth layer at a time
+	Levels []LevelStats
+
+	// the number of links in the path found, or -1 if none was found
+	Depth int
+
+	// true if the path came from a PathCache
+	Cached bool
+}
+
+// Collects SearchStats from the events of a SearchTrace
+type StatsRecorder struct {
+	lock  sync.Mutex
+	stats SearchStats
+}
+
+func NewStatsRecorder() *StatsRecorder {
+	return &StatsRecorder{stats: SearchStats{Depth: -1}}
+}
+
+// Returns a trace that records its events into the recorder
+func (sr *StatsRecorder) Trace() *SearchTrace {
+	return &SearchTrace{
+		OnPageLoad: func(title string, err error) {
+			sr.lock.Lock()
+			defer sr.lock.Unlock()
+
+			sr.stats.PagesLoaded++
+			if err != nil {
+				sr.stats.LoadErrors++
+			}
+		},
+		OnLevel: func(level LevelStats) {
+			sr.lock.Lock()
+			defer sr.lock.Unlock()
+
+			sr.stats.Levels = append(sr.stats.Levels, level)
+		},
+		OnDone: func(depth int) {
+			sr.lock.Lock()
+			defer sr.lock.Unlock()
+
+			sr.stats.Depth = depth
+		},
+		OnCacheHit: func() {
+			sr.lock.Lock()
+			defer sr.lock.Unlock()
+
+			sr.stats.Cached = true
+		},
+	}
+}
+
+// Returns the stats recorded so far
+func (sr *StatsRecorder) Stats() SearchStats {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+
+	stats := sr.stats
+	stats.Levels = append([]LevelStats(nil), sr.stats.Levels...)
+	return stats
+}